@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"fsync/helpers"
+	"os"
 )
 
 func customPrint(inputStr string) {
@@ -16,7 +17,30 @@ func main() {
 		customPrint("Host config built")
 		hosts.VerifyHosts()
 		customPrint("Hosts verified")
+		helpers.ServeMetrics(args.MetricsAddr)
+		helpers.ServeHealth(args.HealthAddr)
 		hosts.StartSync()
+	case "pull":
+		hosts := helpers.BuildHostConfig(args)
+		customPrint("Host config built")
+		hosts.PullHost(args.Target, args.Delete, args.DryRun)
+	case "push":
+		hosts := helpers.BuildHostConfig(args)
+		os.Exit(hosts.PushPaths(args.Paths))
+	case "verify":
+		if args.Target != "" {
+			args.HostNames = append(args.HostNames, args.Target)
+		}
+		hosts := helpers.BuildHostConfig(args)
+		os.Exit(hosts.VerifyContent(args.JSON))
+	case "trash":
+		hosts := helpers.BuildHostConfig(args)
+		hosts.TrashAction(args.Target, args.Host, args.Paths)
+	case "failed":
+		hosts := helpers.BuildHostConfig(args)
+		hosts.FailedAction(args.Target, args.Paths, args.JSON)
+	case "history":
+		helpers.ShowHistory(args.StateDir, args.HostNames, args.Since, args.Paths, args.JSON)
 	case "config":
 		fmt.Println("Selected action config")
 	}
@@ -4,12 +4,20 @@ go 1.21.5
 
 require (
 	github.com/akamensky/argparse v1.4.0
+	github.com/kr/fs v0.1.0
 	github.com/pkg/sftp v1.13.6
+	github.com/prometheus/client_golang v1.19.1
 	github.com/radovskyb/watcher v1.0.7
 	golang.org/x/crypto v0.18.0
+	golang.org/x/term v0.16.0
 )
 
 require (
-	github.com/kr/fs v0.1.0 // indirect
-	golang.org/x/sys v0.16.0 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	golang.org/x/sys v0.17.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
 )
@@ -0,0 +1,348 @@
+package helpers
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/radovskyb/watcher"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Files up to batchFileSize are sent in tar batches of up to batchBytes, larger ones are uploaded on their own
+const batchFileSize = 1 << 20
+const batchBytes = 64 << 20
+const defaultTarThreshold = 500
+const stagingPrefix = ".fsync-staging-"
+
+// useTarBatches - Check if the pending uploads of a sync are sent in tar batches, always with compress
+// and otherwise once there are at least tar_threshold of them
+func (singleHost hostObject) useTarBatches(pending int) bool {
+	return singleHost.Compress || singleHost.passesTarThreshold(pending)
+}
+
+// passesTarThreshold - Check if there are at least tar_threshold uploads
+func (singleHost hostObject) passesTarThreshold(pending int) bool {
+	threshold := singleHost.TarThreshold
+	if threshold == 0 {
+		threshold = defaultTarThreshold
+	}
+
+	return threshold > 0 && pending >= threshold
+}
+
+// batchEventUploads - Send the uploads of a queued batch in tar batches once they pass tar_threshold, returning the
+// local paths which were sent. They run ahead of the other events, so only files which no rename or remove of the
+// batch touches are taken. Small batches, like the saves of an editor, are left to be uploaded one by one.
+func (session *syncSession) batchEventUploads(events []watcher.Event) map[string]bool {
+	sent := make(map[string]bool)
+
+	var moved []string
+	for _, event := range events {
+		if isRenameEvent(event) {
+			moved = append(moved, event.Path, event.OldPath)
+		} else if event.Op == watcher.Remove {
+			moved = append(moved, event.Path)
+		}
+	}
+
+	var pending []string
+	sizes := make(map[string]int64)
+	for _, event := range events {
+		if event.Op != watcher.Create && event.Op != watcher.Write && event.Op != watcher.Chmod {
+			continue
+		}
+		relPath, err := session.Host.relativePath(event.Path)
+		if _, seen := sizes[relPath]; err != nil || seen || relPath == "." || session.Host.isIgnored(relPath) {
+			continue
+		}
+		if slices.Contains(moved, event.Path) || insideAny(event.Path, moved) {
+			continue
+		}
+		info, err := os.Stat(event.Path)
+		if err != nil || !info.Mode().IsRegular() || session.Host.checkFileSize(info.Size()) != nil {
+			continue
+		}
+		pending = append(pending, relPath)
+		sizes[relPath] = info.Size()
+	}
+	if !session.Host.passesTarThreshold(len(pending)) {
+		return sent
+	}
+
+	// Files are moved out of the staging directory into their parents, which new directories don't have yet
+	sort.Strings(pending)
+	parents := make(map[string]bool)
+	for _, relPath := range pending {
+		if parent := path.Dir(relPath); parent != "." && !parents[parent] {
+			parents[parent] = true
+			session.makeRemoteDir(parent)
+		}
+	}
+
+	batches, _ := session.splitBatches(pending, sizes)
+	for _, batch := range batches {
+		_, remaining := session.uploadBatch(batch)
+		for _, relPath := range batch {
+			if !slices.Contains(remaining, relPath) {
+				sent[session.Host.localPath(relPath)] = true
+			}
+		}
+	}
+
+	return sent
+}
+
+// splitBatches - Group the files small enough for a tar batch, returning the batches and the files left for single uploads
+func (session *syncSession) splitBatches(relPaths []string, sizes map[string]int64) ([][]string, []string) {
+	var batches [][]string
+	var single, batch []string
+	var batchSize int64
+
+	for _, relPath := range relPaths {
+		if sizes[relPath] > batchFileSize {
+			single = append(single, relPath)
+			continue
+		}
+		if batchSize+sizes[relPath] > batchBytes && len(batch) > 0 {
+			batches = append(batches, batch)
+			batch, batchSize = nil, 0
+		}
+		batch = append(batch, relPath)
+		batchSize += sizes[relPath]
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+
+	return batches, single
+}
+
+// uploadBatch - Send files as one tar stream unpacked into a staging directory on the host, which saves the
+// round trips of single uploads. The files are confirmed in the staging directory and only then moved into
+// place, so an interrupted batch never leaves partial files behind. Files which couldn't be confirmed are
+// returned so they can be uploaded on their own.
+func (session *syncSession) uploadBatch(relPaths []string) (int, []string) {
+	start := time.Now()
+	board.update(session.PetName, func(status *hostStatus) {
+		status.Transfer, status.Sent, status.Total = fmt.Sprintf("batch of %d files", len(relPaths)), 0, 0
+	})
+	defer board.update(session.PetName, func(status *hostStatus) { status.Transfer = "" })
+
+	if session.Remote == nil {
+		return 0, relPaths
+	}
+	stagingDir := path.Join(session.Host.RemoteDir, fmt.Sprintf("%s%d-%d", stagingPrefix, os.Getpid(), start.UnixNano()))
+	defer session.Remote.FS.RemoveAll(stagingDir)
+
+	localSums, sizes, err := session.sendTarBatch(stagingDir, relPaths)
+	if err != nil {
+		fmt.Printf("[%s] Unable to send a batch of %d files, uploading them one by one: %s\n", session.PetName, len(relPaths), err)
+		return 0, relPaths
+	}
+
+	mismatched := session.verifyBatch(stagingDir, relPaths, localSums, sizes)
+
+	var confirmed, remaining []string
+	for _, relPath := range relPaths {
+		if mismatched[relPath] {
+			remaining = append(remaining, relPath)
+			continue
+		}
+		if err := session.Remote.applyOwnership(session.Host, path.Join(stagingDir, relPath), false); err != nil {
+			fmt.Printf("[%s] Unable to apply ownership to %s: %s\n", session.PetName, relPath, err)
+			remaining = append(remaining, relPath)
+			continue
+		}
+		confirmed = append(confirmed, relPath)
+	}
+
+	if err = session.moveIntoPlace(stagingDir, confirmed); err != nil {
+		fmt.Printf("[%s] Unable to move a batch of %d files into place, uploading them one by one: %s\n", session.PetName, len(confirmed), err)
+		return 0, relPaths
+	}
+
+	for _, relPath := range confirmed {
+		session.record("upload", relPath, session.Host.remotePath(relPath), sizes[relPath], start, localSums[relPath], nil)
+		session.Config.Failed.resolve(session.PetName, session.Host.localPath(relPath))
+	}
+	fmt.Printf("[%s] Sent a batch of %d files in %s\n", session.PetName, len(confirmed), time.Since(start).Round(time.Millisecond))
+
+	return len(confirmed), remaining
+}
+
+// moveIntoPlace - Rename the confirmed files from the staging directory over their destinations with a single command
+func (session *syncSession) moveIntoPlace(stagingDir string, relPaths []string) error {
+	if len(relPaths) == 0 {
+		return nil
+	}
+
+	sshSession, err := session.Remote.SSH.NewSession()
+	if err != nil {
+		return err
+	}
+	defer sshSession.Close()
+
+	var input, stderr strings.Builder
+	for _, relPath := range relPaths {
+		input.WriteString(relPath + "\x00")
+	}
+	sshSession.Stdin = strings.NewReader(input.String())
+	sshSession.Stderr = &stderr
+
+	// The staging directory is directly inside RemoteDir. -T keeps a file from being moved into a directory of the same name.
+	command := "cd " + shellQuote(stagingDir) + ` && xargs -0 sh -c 'for f; do mv -fT -- "$f" "../$f" || exit 255; done' sh`
+	if err = sshSession.Run(command); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return errors.New(message)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// sendTarBatch - Stream the files into tar unpacking them in stagingDir, gzip compressed with compress.
+// The SHA-256 and size of what was sent are returned.
+func (session *syncSession) sendTarBatch(stagingDir string, relPaths []string) (map[string]string, map[string]int64, error) {
+	sshSession, err := session.Remote.SSH.NewSession()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer sshSession.Close()
+
+	var stderr strings.Builder
+	sshSession.Stderr = &stderr
+	stdin, err := sshSession.StdinPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	flags := "--no-same-owner --no-same-permissions"
+	if session.Host.PreserveMode || session.Host.FileMode != "" {
+		flags = "--no-same-owner --same-permissions"
+	}
+	if !session.Host.PreserveTimes {
+		flags += " --touch"
+	}
+	var stream io.WriteCloser = stdin
+	if session.Host.Compress {
+		flags += " -z"
+		stream = gzip.NewWriter(stdin)
+	}
+	command := fmt.Sprintf("mkdir -p -- %[1]s && tar -xf - %[2]s -C %[1]s", shellQuote(stagingDir), flags)
+	if err = sshSession.Start(command); err != nil {
+		return nil, nil, err
+	}
+
+	localSums := make(map[string]string)
+	sizes := make(map[string]int64)
+	archive := tar.NewWriter(stream)
+	writeErr := func() error {
+		for _, relPath := range relPaths {
+			if err := session.writeTarEntry(archive, relPath, localSums, sizes); err != nil {
+				return err
+			}
+		}
+		if err := archive.Close(); err != nil {
+			return err
+		}
+		return stream.Close()
+	}()
+	stdin.Close()
+
+	if err = sshSession.Wait(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, nil, errors.New(message)
+		}
+		return nil, nil, fmt.Errorf("tar: %w", err)
+	}
+	if writeErr != nil {
+		return nil, nil, writeErr
+	}
+
+	return localSums, sizes, nil
+}
+
+// writeTarEntry - Add a single local file to the archive with the mode and time it should have on the host
+func (session *syncSession) writeTarEntry(archive *tar.Writer, relPath string, localSums map[string]string, sizes map[string]int64) error {
+	localFile, err := os.Open(session.Host.localPath(relPath))
+	if err != nil {
+		return err
+	}
+	defer localFile.Close()
+
+	info, err := localFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0644)
+	if session.Host.FileMode != "" {
+		mode = session.Host.fileMode
+	} else if session.Host.PreserveMode {
+		mode = info.Mode().Perm()
+	}
+
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     relPath,
+		Size:     info.Size(),
+		Mode:     int64(octalMode(mode)),
+		// tar rounds to the nearest second while SFTP truncates, which would make the times differ
+		ModTime: info.ModTime().Truncate(time.Second),
+	}
+	if err = archive.WriteHeader(header); err != nil {
+		return err
+	}
+
+	hasher := sha256.New()
+	written, err := io.CopyN(archive, io.TeeReader(localFile, hasher), info.Size())
+	if err != nil {
+		return fmt.Errorf("%s changed while sending it, %d of %d bytes read: %w", relPath, written, info.Size(), err)
+	}
+
+	localSums[relPath] = hex.EncodeToString(hasher.Sum(nil))
+	sizes[relPath] = info.Size()
+	return nil
+}
+
+// verifyBatch - Confirm the unpacked files as configured with verify, returning the ones which don't match
+func (session *syncSession) verifyBatch(stagingDir string, relPaths []string, localSums map[string]string, sizes map[string]int64) map[string]bool {
+	mismatched := make(map[string]bool)
+
+	switch session.Host.Verify {
+	case VerifyNone:
+	case VerifySize:
+		for _, relPath := range relPaths {
+			info, err := session.Remote.FS.Stat(path.Join(stagingDir, relPath))
+			if err != nil || info.Size() != sizes[relPath] {
+				mismatched[relPath] = true
+			}
+		}
+	default:
+		remoteSums, err := session.Remote.checksums(stagingDir, relPaths)
+		if err != nil {
+			fmt.Printf("[%s] Unable to verify the batch: %s\n", session.PetName, err)
+		}
+		for _, relPath := range relPaths {
+			if remoteSums[relPath] != localSums[relPath] {
+				mismatched[relPath] = true
+			}
+		}
+	}
+
+	if len(mismatched) > 0 {
+		fmt.Printf("[%s] %d files of the batch don't match, uploading them again\n", session.PetName, len(mismatched))
+	}
+
+	return mismatched
+}
@@ -0,0 +1,215 @@
+package helpers
+
+import (
+	"bufio"
+	"fmt"
+	"golang.org/x/term"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const dashboardRefresh = 250 * time.Millisecond
+const dashboardLogLines = 500
+const dashboardExitLines = 20
+
+// hostStatus - Live state of a single host as shown on the dashboard
+type hostStatus struct {
+	State     string
+	Transfer  string
+	Sent      int64
+	Total     int64
+	Queue     int
+	Uploaded  int
+	Failed    int
+	LastError string
+
+	// UnreachableSince - When the host stopped responding, zero while it is connected
+	UnreachableSince time.Time
+}
+
+// statusBoard - State of every host and the recent log lines, kept whether the dashboard is shown or not
+type statusBoard struct {
+	mutex sync.Mutex
+	hosts map[string]*hostStatus
+	names []string
+	log   []string
+}
+
+var board = &statusBoard{hosts: make(map[string]*hostStatus)}
+
+// update - Change the status of a host, adding its row on first use
+func (board *statusBoard) update(petName string, change func(status *hostStatus)) {
+	board.mutex.Lock()
+	defer board.mutex.Unlock()
+
+	status, ok := board.hosts[petName]
+	if !ok {
+		status = &hostStatus{State: "connecting"}
+		board.hosts[petName] = status
+		board.names = append(board.names, petName)
+		sort.Strings(board.names)
+	}
+	change(status)
+}
+
+// appendLog - Add a line to the event log, dropping the oldest ones past dashboardLogLines
+func (board *statusBoard) appendLog(line string) {
+	board.mutex.Lock()
+	defer board.mutex.Unlock()
+
+	board.log = append(board.log, line)
+	if len(board.log) > dashboardLogLines {
+		board.log = board.log[len(board.log)-dashboardLogLines:]
+	}
+}
+
+// dashboardState - Terminal taken over by the dashboard and the pipe which replaced stdout
+type dashboardState struct {
+	mutex    sync.Mutex
+	terminal *os.File
+	writer   *os.File
+	logDone  chan struct{}
+}
+
+var dashboard dashboardState
+
+// startDashboard - Take over the terminal with a live view of the hosts, the regular output
+// becomes the scrolling event log. Without a terminal on stdout the plain log is kept.
+func startDashboard() {
+	terminal := os.Stdout
+	if !term.IsTerminal(int(terminal.Fd())) {
+		fmt.Println("Stdout isn't a terminal, falling back to plain logs")
+		return
+	}
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		fmt.Println("Unable to start the dashboard:", err)
+		return
+	}
+
+	dashboard.mutex.Lock()
+	dashboard.terminal = terminal
+	dashboard.writer = writer
+	dashboard.logDone = make(chan struct{})
+	os.Stdout = writer
+	dashboard.mutex.Unlock()
+
+	go func() {
+		defer close(dashboard.logDone)
+		scanner := bufio.NewScanner(reader)
+		for scanner.Scan() {
+			board.appendLog(scanner.Text())
+		}
+	}()
+
+	// Switch to the alternate screen and hide the cursor while drawing
+	fmt.Fprint(terminal, "\x1b[?1049h\x1b[?25l")
+	go func() {
+		ticker := time.NewTicker(dashboardRefresh)
+		defer ticker.Stop()
+		for range ticker.C {
+			dashboard.mutex.Lock()
+			if dashboard.terminal == nil {
+				dashboard.mutex.Unlock()
+				return
+			}
+			board.draw(terminal)
+			dashboard.mutex.Unlock()
+		}
+	}()
+}
+
+// stopDashboard - Give the terminal back and print the end of the event log on it, so the reason for exiting stays
+// visible. Has to run before every exit while the dashboard is shown, does nothing otherwise.
+func stopDashboard() {
+	dashboard.mutex.Lock()
+	defer dashboard.mutex.Unlock()
+	if dashboard.terminal == nil {
+		return
+	}
+
+	terminal := dashboard.terminal
+	dashboard.terminal = nil
+	os.Stdout = terminal
+
+	// Closing the pipe lets the log reader take in the remaining lines before they are printed
+	dashboard.writer.Close()
+	<-dashboard.logDone
+
+	fmt.Fprint(terminal, "\x1b[?25h\x1b[?1049l")
+	board.mutex.Lock()
+	for _, line := range board.log[max(0, len(board.log)-dashboardExitLines):] {
+		fmt.Fprintln(terminal, line)
+	}
+	board.mutex.Unlock()
+}
+
+// draw - Render one row per host followed by as much of the event log as fits the terminal
+func (board *statusBoard) draw(terminal *os.File) {
+	width, height, err := term.GetSize(int(terminal.Fd()))
+	if err != nil {
+		width, height = 120, 40
+	}
+
+	board.mutex.Lock()
+	lines := []string{
+		fmt.Sprintf("fsync - %d hosts - %s", len(board.names), time.Now().Format(time.TimeOnly)),
+		fmt.Sprintf("%-16s %-12s %6s %9s %7s  %s", "HOST", "STATE", "QUEUE", "UPLOADED", "FAILED", "TRANSFER"),
+	}
+	for _, petName := range board.names {
+		status := board.hosts[petName]
+		transfer := "-"
+		if status.Transfer != "" {
+			transfer = fmt.Sprintf("%s %s/%s", status.Transfer, formatBytes(status.Sent), formatBytes(status.Total))
+			if status.Total > 0 {
+				transfer += fmt.Sprintf(" (%d%%)", status.Sent*100/status.Total)
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%-16s %-12s %6d %9d %7d  %s", petName, status.State, status.Queue, status.Uploaded, status.Failed, transfer))
+		if status.LastError != "" {
+			lines = append(lines, "  last error: "+status.LastError)
+		}
+	}
+	lines = append(lines, strings.Repeat("-", width))
+
+	// The header and hosts may already fill the terminal, leaving no room for the log
+	logRows := min(max(0, height-len(lines)), len(board.log))
+	lines = append(lines, board.log[len(board.log)-logRows:]...)
+	board.mutex.Unlock()
+
+	var frame strings.Builder
+	frame.WriteString("\x1b[H\x1b[2J")
+	for index, line := range lines {
+		if index >= height {
+			break
+		}
+		if len(line) > width {
+			line = line[:width]
+		}
+		frame.WriteString(line)
+		if index < len(lines)-1 && index < height-1 {
+			frame.WriteString("\r\n")
+		}
+	}
+	fmt.Fprint(terminal, frame.String())
+}
+
+// formatBytes - Human readable size
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+
+	divisor, exponent := int64(unit), 0
+	for value := size / unit; value >= unit; value /= unit {
+		divisor *= unit
+		exponent++
+	}
+
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(divisor), "KMGTPE"[exponent])
+}
@@ -0,0 +1,173 @@
+package helpers
+
+import (
+	"github.com/radovskyb/watcher"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// isEditorArtefact - Check a file name against the temp and backup files editors create while saving.
+// Vim probes the directory with 4913 (then 5036, 5159, ...), keeps backups as name~ and swap files as .name.swp,
+// JetBrains IDEs write name___jb_tmp___ and keep the previous version as name___jb_old___.
+func isEditorArtefact(name string) bool {
+	if strings.HasSuffix(name, "~") || strings.HasSuffix(name, "___jb_tmp___") || strings.HasSuffix(name, "___jb_old___") {
+		return true
+	}
+
+	if strings.HasPrefix(name, ".") {
+		extension := filepath.Ext(name)
+		if extension == ".swx" || (len(extension) == 4 && strings.HasPrefix(extension, ".sw") && extension[3] >= 'a' && extension[3] <= 'p') {
+			return true
+		}
+	}
+
+	if number, err := strconv.Atoi(name); err == nil && number >= 4913 && number < 100000 && (number-4913)%123 == 0 {
+		return true
+	}
+
+	return false
+}
+
+// coalesceEvents - Reduce a batch to the changes which matter for the remote.
+// Events on editor artefacts are dropped, a temp file renamed over the original becomes a write of the original
+// and of several events on the same path only the last one is kept, so a save turns into a single upload.
+func coalesceEvents(events []watcher.Event) []watcher.Event {
+	events = dropChildRemoves(dropChildRenames(events))
+
+	var kept []watcher.Event
+	var dropped []bool
+	latest := make(map[string]int)
+
+	for _, event := range events {
+		newArtefact := isEditorArtefact(filepath.Base(event.Path))
+
+		if isRenameEvent(event) {
+			oldArtefact := isEditorArtefact(filepath.Base(event.OldPath))
+			switch {
+			case newArtefact && oldArtefact:
+				continue
+			case oldArtefact:
+				// Temp file renamed over the original
+				event = watcher.Event{Op: watcher.Write, Path: event.Path, FileInfo: event.FileInfo}
+			case newArtefact:
+				// Original moved aside as a backup, unless a new version follows it is gone
+				event = watcher.Event{Op: watcher.Remove, Path: event.OldPath, FileInfo: event.FileInfo}
+			default:
+				// Earlier events on either side must stay in front of the rename
+				delete(latest, event.Path)
+				delete(latest, event.OldPath)
+				kept = append(kept, event)
+				dropped = append(dropped, false)
+				continue
+			}
+		} else if newArtefact {
+			continue
+		}
+
+		if index, ok := latest[event.Path]; ok {
+			// A remove followed by a create is the file being replaced, which is a plain write
+			if kept[index].Op == watcher.Remove && event.Op == watcher.Create && (event.FileInfo == nil || !event.IsDir()) {
+				event.Op = watcher.Write
+			}
+			dropped[index] = true
+		}
+		latest[event.Path] = len(kept)
+		kept = append(kept, event)
+		dropped = append(dropped, false)
+	}
+
+	var result []watcher.Event
+	for index, event := range kept {
+		if !dropped[index] {
+			result = append(result, event)
+		}
+	}
+
+	return result
+}
+
+// dropChildRenames - A renamed directory is reported along with a rename of everything inside it,
+// the remote rename of the directory already covers those. The watcher reports the changes of one poll in no
+// particular order, so directory renames are moved to the front to run before anything is moved into them.
+func dropChildRenames(events []watcher.Event) []watcher.Event {
+	var dirRenames []watcher.Event
+	for _, event := range events {
+		if isRenameEvent(event) && event.FileInfo != nil && event.IsDir() {
+			dirRenames = append(dirRenames, event)
+		}
+	}
+	if len(dirRenames) == 0 {
+		return events
+	}
+
+	var first, rest []watcher.Event
+	for _, event := range events {
+		switch {
+		case !isRenameEvent(event):
+			rest = append(rest, event)
+		case renamedWith(event, dirRenames):
+			continue
+		case event.FileInfo != nil && event.IsDir():
+			first = append(first, event)
+		default:
+			rest = append(rest, event)
+		}
+	}
+
+	return append(first, rest...)
+}
+
+// dropChildRemoves - A removed directory is reported along with a remove of everything inside it. Removing or
+// trashing the directory on the remote already covers those, while applying them first would leave a half
+// emptied directory behind and, with delete_policy trash, a trash target the directory can't be moved onto.
+func dropChildRemoves(events []watcher.Event) []watcher.Event {
+	var removedDirs []string
+	for _, event := range events {
+		if event.Op == watcher.Remove && event.FileInfo != nil && event.IsDir() {
+			removedDirs = append(removedDirs, event.Path)
+		}
+	}
+	if len(removedDirs) == 0 {
+		return events
+	}
+
+	var result []watcher.Event
+	for _, event := range events {
+		if event.Op == watcher.Remove && insideAny(event.Path, removedDirs) {
+			continue
+		}
+		result = append(result, event)
+	}
+
+	return result
+}
+
+// insideAny - Check if a path lies below one of the directories
+func insideAny(eventPath string, dirs []string) bool {
+	for _, dir := range dirs {
+		relPath, err := filepath.Rel(dir, eventPath)
+		if err == nil && relPath != "." && relPath != ".." && !strings.HasPrefix(relPath, "../") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isRenameEvent - Check for an event which carries both an old and a new path
+func isRenameEvent(event watcher.Event) bool {
+	return event.Op == watcher.Rename || event.Op == watcher.Move
+}
+
+// renamedWith - Check if a rename only follows from one of the directory renames
+func renamedWith(event watcher.Event, dirRenames []watcher.Event) bool {
+	for _, dirRename := range dirRenames {
+		relPath, err := filepath.Rel(dirRename.OldPath, event.OldPath)
+		if err == nil && relPath != "." && !strings.HasPrefix(relPath, "..") && event.Path == filepath.Join(dirRename.Path, relPath) {
+			return true
+		}
+	}
+
+	return false
+}
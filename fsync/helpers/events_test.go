@@ -0,0 +1,189 @@
+package helpers
+
+import (
+	"github.com/radovskyb/watcher"
+	"os"
+	"testing"
+	"time"
+)
+
+// testFileInfo - Minimal os.FileInfo to mark events as directories
+type testFileInfo struct {
+	name string
+	dir  bool
+}
+
+func (info testFileInfo) Name() string       { return info.name }
+func (info testFileInfo) Size() int64        { return 0 }
+func (info testFileInfo) ModTime() time.Time { return time.Time{} }
+func (info testFileInfo) IsDir() bool        { return info.dir }
+func (info testFileInfo) Sys() interface{}   { return nil }
+func (info testFileInfo) Mode() os.FileMode {
+	if info.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+func fileEvent(op watcher.Op, eventPath string) watcher.Event {
+	return watcher.Event{Op: op, Path: eventPath, FileInfo: testFileInfo{name: eventPath}}
+}
+
+func dirEvent(op watcher.Op, eventPath string) watcher.Event {
+	return watcher.Event{Op: op, Path: eventPath, FileInfo: testFileInfo{name: eventPath, dir: true}}
+}
+
+func renamedEvent(oldPath string, newPath string, dir bool) watcher.Event {
+	return watcher.Event{Op: watcher.Rename, Path: newPath, OldPath: oldPath, FileInfo: testFileInfo{name: newPath, dir: dir}}
+}
+
+func TestCoalesceEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []watcher.Event
+		want   []watcher.Event
+	}{
+		{
+			name: "vim backup rename",
+			events: []watcher.Event{
+				fileEvent(watcher.Create, "/src/4913"),
+				fileEvent(watcher.Remove, "/src/4913"),
+				renamedEvent("/src/main.go", "/src/main.go~", false),
+				fileEvent(watcher.Create, "/src/main.go"),
+				fileEvent(watcher.Write, "/src/main.go"),
+				fileEvent(watcher.Remove, "/src/main.go~"),
+			},
+			want: []watcher.Event{fileEvent(watcher.Write, "/src/main.go")},
+		},
+		{
+			name: "vim swap files",
+			events: []watcher.Event{
+				fileEvent(watcher.Create, "/src/.main.go.swp"),
+				fileEvent(watcher.Write, "/src/main.go"),
+				fileEvent(watcher.Write, "/src/.main.go.swp"),
+			},
+			want: []watcher.Event{fileEvent(watcher.Write, "/src/main.go")},
+		},
+		{
+			name: "jetbrains safe write",
+			events: []watcher.Event{
+				fileEvent(watcher.Create, "/src/main.go___jb_tmp___"),
+				fileEvent(watcher.Write, "/src/main.go___jb_tmp___"),
+				renamedEvent("/src/main.go", "/src/main.go___jb_old___", false),
+				renamedEvent("/src/main.go___jb_tmp___", "/src/main.go", false),
+				fileEvent(watcher.Remove, "/src/main.go___jb_old___"),
+			},
+			want: []watcher.Event{fileEvent(watcher.Write, "/src/main.go")},
+		},
+		{
+			name: "remove and create is a write",
+			events: []watcher.Event{
+				fileEvent(watcher.Remove, "/src/main.go"),
+				fileEvent(watcher.Create, "/src/main.go"),
+			},
+			want: []watcher.Event{fileEvent(watcher.Write, "/src/main.go")},
+		},
+		{
+			name: "replaced directory stays a create",
+			events: []watcher.Event{
+				dirEvent(watcher.Remove, "/src/pkg"),
+				dirEvent(watcher.Create, "/src/pkg"),
+			},
+			want: []watcher.Event{dirEvent(watcher.Create, "/src/pkg")},
+		},
+		{
+			name: "repeated writes",
+			events: []watcher.Event{
+				fileEvent(watcher.Write, "/src/main.go"),
+				fileEvent(watcher.Write, "/src/other.go"),
+				fileEvent(watcher.Write, "/src/main.go"),
+			},
+			want: []watcher.Event{
+				fileEvent(watcher.Write, "/src/other.go"),
+				fileEvent(watcher.Write, "/src/main.go"),
+			},
+		},
+		{
+			name: "write before rename keeps its order",
+			events: []watcher.Event{
+				fileEvent(watcher.Write, "/src/a.go"),
+				renamedEvent("/src/a.go", "/src/b.go", false),
+			},
+			want: []watcher.Event{
+				fileEvent(watcher.Write, "/src/a.go"),
+				renamedEvent("/src/a.go", "/src/b.go", false),
+			},
+		},
+		{
+			name: "directory rename with child renames",
+			events: []watcher.Event{
+				fileEvent(watcher.Write, "/src/main.go"),
+				renamedEvent("/src/old/a.go", "/src/new/a.go", false),
+				renamedEvent("/src/old/sub", "/src/new/sub", true),
+				renamedEvent("/src/old", "/src/new", true),
+				renamedEvent("/src/old/sub/b.go", "/src/new/sub/b.go", false),
+				renamedEvent("/src/c.go", "/src/new/c.go", false),
+			},
+			want: []watcher.Event{
+				renamedEvent("/src/old", "/src/new", true),
+				fileEvent(watcher.Write, "/src/main.go"),
+				renamedEvent("/src/c.go", "/src/new/c.go", false),
+			},
+		},
+		{
+			name: "directory remove with child removes",
+			events: []watcher.Event{
+				fileEvent(watcher.Remove, "/src/pkg/sub/b.go"),
+				fileEvent(watcher.Remove, "/src/pkg/a.go"),
+				dirEvent(watcher.Remove, "/src/pkg/sub"),
+				dirEvent(watcher.Remove, "/src/pkg"),
+				fileEvent(watcher.Remove, "/src/pkg.go"),
+			},
+			want: []watcher.Event{
+				dirEvent(watcher.Remove, "/src/pkg"),
+				fileEvent(watcher.Remove, "/src/pkg.go"),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := coalesceEvents(test.events)
+			if len(got) != len(test.want) {
+				t.Fatalf("got %d events %v, want %d %v", len(got), got, len(test.want), test.want)
+			}
+			for index := range got {
+				if got[index].Op != test.want[index].Op || got[index].Path != test.want[index].Path || got[index].OldPath != test.want[index].OldPath {
+					t.Errorf("event %d is %v, want %v", index, got[index], test.want[index])
+				}
+			}
+		})
+	}
+}
+
+func TestIsEditorArtefact(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"main.go", false},
+		{"main.go~", true},
+		{".main.go.swp", true},
+		{".main.go.swo", true},
+		{".main.go.swx", true},
+		{"main.go.swp", false},
+		{".main.go.swz", false},
+		{"4913", true},
+		{"5036", true},
+		{"4914", false},
+		{"123", false},
+		{"main.go___jb_tmp___", true},
+		{"main.go___jb_old___", true},
+	}
+
+	for _, test := range tests {
+		if got := isEditorArtefact(test.name); got != test.want {
+			t.Errorf("isEditorArtefact(%q) = %t, want %t", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package helpers
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// expandVars - Expand $VAR and ${VAR}, failing on variables which aren't set
+func expandVars(value string) (string, error) {
+	var missing []string
+	expanded := os.Expand(value, func(name string) string {
+		variable, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return variable
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s used in %q isn't set", strings.Join(missing, ", "), value)
+	}
+
+	return expanded, nil
+}
+
+// expandLocalPath - Expand environment variables and a leading ~ to the local home directory
+func expandLocalPath(value string) (string, error) {
+	expanded, err := expandVars(value)
+	if err != nil {
+		return "", err
+	}
+
+	if expanded == "~" || strings.HasPrefix(expanded, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("unable to expand ~ in %q: %w", value, err)
+		}
+		expanded = home + expanded[1:]
+	}
+
+	return expanded, nil
+}
+
+// expandHostConfig - Expand the environment variables and ~ in the fields of a host.
+// A leading ~ in remote_dir is kept, it refers to the remote home which is only known after connecting.
+func (singleHost hostObject) expandHostConfig() (hostObject, error) {
+	var err error
+
+	if singleHost.Hostname, err = expandVars(singleHost.Hostname); err != nil {
+		return singleHost, fmt.Errorf("hostname: %w", err)
+	}
+	if singleHost.User, err = expandVars(singleHost.User); err != nil {
+		return singleHost, fmt.Errorf("user: %w", err)
+	}
+	if singleHost.LocalDir, err = expandLocalPath(singleHost.LocalDir); err != nil {
+		return singleHost, fmt.Errorf("local_dir: %w", err)
+	}
+	if singleHost.RemoteDir, err = expandVars(singleHost.RemoteDir); err != nil {
+		return singleHost, fmt.Errorf("remote_dir: %w", err)
+	}
+
+	return singleHost, nil
+}
+
+// expandRemoteHome - Replace a leading ~ with the working directory of the remote session
+func expandRemoteHome(client remoteFS, remoteDir string) (string, error) {
+	if remoteDir != "~" && !strings.HasPrefix(remoteDir, "~/") {
+		return remoteDir, nil
+	}
+
+	home, err := client.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("unable to find the remote home directory: %w", err)
+	}
+
+	return path.Join(home, strings.TrimPrefix(remoteDir[1:], "/")), nil
+}
+
+// remoteDirData - Values available in a templated remote_dir, each is only looked up when the template uses it
+type remoteDirData struct {
+	host hostObject
+}
+
+// Branch - Current branch of the repository in LocalDir with slashes replaced, the short commit when detached
+func (data remoteDirData) Branch() (string, error) {
+	output, err := data.host.gitCommand(nil, "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	branch := strings.TrimSpace(string(output))
+	if branch == "HEAD" {
+		if output, err = data.host.gitCommand(nil, "rev-parse", "--short", "HEAD"); err != nil {
+			return "", err
+		}
+		branch = strings.TrimSpace(string(output))
+	}
+
+	return strings.ReplaceAll(branch, "/", "-"), nil
+}
+
+// User - Name of the local user
+func (data remoteDirData) User() (string, error) {
+	current, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+
+	return current.Username, nil
+}
+
+// Host - Name of the local machine
+func (data remoteDirData) Host() (string, error) {
+	return os.Hostname()
+}
+
+// renderRemoteDir - Fill in the template of remote_dir, hosts without one keep their RemoteDir
+func (singleHost hostObject) renderRemoteDir() (string, error) {
+	if singleHost.remoteDirTemplate == "" {
+		return singleHost.RemoteDir, nil
+	}
+
+	remoteDirTemplate, err := template.New("remote_dir").Parse(singleHost.remoteDirTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	var remoteDir strings.Builder
+	if err = remoteDirTemplate.Execute(&remoteDir, remoteDirData{host: singleHost}); err != nil {
+		return "", err
+	}
+
+	return path.Clean(remoteDir.String()), nil
+}
@@ -0,0 +1,91 @@
+package helpers
+
+import (
+	"os"
+	"testing"
+)
+
+// homeFS - Transport whose sessions start in a fixed directory
+type homeFS struct {
+	remoteFS
+	home string
+}
+
+func (client homeFS) Getwd() (string, error) {
+	return client.home, nil
+}
+
+func TestExpandVars(t *testing.T) {
+	t.Setenv("FSYNC_TEST_USER", "deploy")
+	t.Setenv("FSYNC_TEST_EMPTY", "")
+	os.Unsetenv("FSYNC_TEST_MISSING")
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", ""},
+		{"/srv/app", "/srv/app"},
+		{"$FSYNC_TEST_USER", "deploy"},
+		{"/home/${FSYNC_TEST_USER}/app", "/home/deploy/app"},
+		{"/srv/$FSYNC_TEST_EMPTY", "/srv/"},
+		{"~/app", "~/app"},
+	}
+
+	for _, test := range tests {
+		got, err := expandVars(test.value)
+		if err != nil || got != test.want {
+			t.Errorf("expandVars(%q) = %q, %v, want %q", test.value, got, err, test.want)
+		}
+	}
+
+	if _, err := expandVars("/srv/$FSYNC_TEST_MISSING/app"); err == nil {
+		t.Error("expandVars didn't fail on a variable which isn't set")
+	}
+}
+
+func TestExpandLocalPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("FSYNC_TEST_DIR", "project")
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"~", home},
+		{"~/project", home + "/project"},
+		{"~/$FSYNC_TEST_DIR", home + "/project"},
+		{"/srv/~/project", "/srv/~/project"},
+		{"~other/project", "~other/project"},
+		{"relative/$FSYNC_TEST_DIR", "relative/project"},
+	}
+
+	for _, test := range tests {
+		got, err := expandLocalPath(test.value)
+		if err != nil || got != test.want {
+			t.Errorf("expandLocalPath(%q) = %q, %v, want %q", test.value, got, err, test.want)
+		}
+	}
+}
+
+func TestExpandRemoteHome(t *testing.T) {
+	client := homeFS{home: "/home/deploy"}
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"~", "/home/deploy"},
+		{"~/app", "/home/deploy/app"},
+		{"~/app/", "/home/deploy/app"},
+		{"/srv/app", "/srv/app"},
+		{"~other/app", "~other/app"},
+	}
+
+	for _, test := range tests {
+		got, err := expandRemoteHome(client, test.value)
+		if err != nil || got != test.want {
+			t.Errorf("expandRemoteHome(%q) = %q, %v, want %q", test.value, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package helpers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pkg/sftp"
+	"github.com/radovskyb/watcher"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const failedStateFileName = "failed.json"
+const retryAttempts = 5
+const retryBaseDelay = time.Second
+const retryMaxDelay = 30 * time.Second
+
+// failedOperation - Change which couldn't be applied to a host even after retrying
+type failedOperation struct {
+	Host      string    `json:"host"`
+	Operation string    `json:"operation"`
+	LocalPath string    `json:"local_path"`
+	OldPath   string    `json:"old_path,omitempty"`
+	Error     string    `json:"error"`
+	Permanent bool      `json:"permanent"`
+	Failures  int       `json:"failures"`
+	Time      time.Time `json:"time"`
+}
+
+// deadLetters - Failed operations of all hosts, persisted in the state directory until they are retried or dropped
+type deadLetters struct {
+	path       string
+	mutex      sync.Mutex
+	Operations map[string]failedOperation `json:"operations"`
+}
+
+// loadDeadLetters - Read the failed operations left over from previous runs
+func loadDeadLetters(stateDir string) (*deadLetters, error) {
+	letters := &deadLetters{path: filepath.Join(stateDir, failedStateFileName), Operations: make(map[string]failedOperation)}
+
+	data, err := os.ReadFile(letters.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return letters, nil
+		}
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, letters); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", letters.path, err)
+	}
+	if letters.Operations == nil {
+		letters.Operations = make(map[string]failedOperation)
+	}
+
+	return letters, nil
+}
+
+// add - Record an event which couldn't be applied, a path which already failed before keeps a single entry
+func (letters *deadLetters) add(petName string, event watcher.Event, err error) {
+	if letters == nil {
+		return
+	}
+
+	operation := failedOperation{
+		Host:      petName,
+		Operation: "upload",
+		LocalPath: event.Path,
+		Error:     err.Error(),
+		Permanent: isPermanent(err),
+		Failures:  1,
+		Time:      time.Now().UTC(),
+	}
+	switch event.Op {
+	case watcher.Remove:
+		operation.Operation = "remove"
+	case watcher.Rename, watcher.Move:
+		operation.Operation = "rename"
+		operation.OldPath = event.OldPath
+	}
+
+	letters.mutex.Lock()
+	defer letters.mutex.Unlock()
+
+	if previous, ok := letters.Operations[petName+":"+event.Path]; ok {
+		operation.Failures += previous.Failures
+	}
+	letters.Operations[petName+":"+event.Path] = operation
+	if saveErr := letters.save(); saveErr != nil {
+		fmt.Println("Unable to write failed operations:", saveErr)
+	}
+}
+
+// resolve - Forget the failed operation of a path once a change of it was applied
+func (letters *deadLetters) resolve(petName string, localPath string) {
+	if letters == nil {
+		return
+	}
+
+	letters.mutex.Lock()
+	defer letters.mutex.Unlock()
+
+	if _, ok := letters.Operations[petName+":"+localPath]; !ok {
+		return
+	}
+	delete(letters.Operations, petName+":"+localPath)
+	if err := letters.save(); err != nil {
+		fmt.Println("Unable to write failed operations:", err)
+	}
+}
+
+// selected - Failed operations of the given hosts below the given paths, oldest first
+func (letters *deadLetters) selected(hostsMap map[string]hostObject, paths []string) []failedOperation {
+	letters.mutex.Lock()
+	defer letters.mutex.Unlock()
+
+	var result []failedOperation
+	for _, operation := range letters.Operations {
+		if _, ok := hostsMap[operation.Host]; !ok {
+			continue
+		}
+		if len(paths) > 0 && !pathSelected(paths, operation.LocalPath, operation.OldPath) {
+			continue
+		}
+		result = append(result, operation)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Time.Before(result[j].Time) })
+
+	return result
+}
+
+// save - Write failed.json, the mutex needs to be held by the caller
+func (letters *deadLetters) save() error {
+	return writeStateFile(letters.path, letters)
+}
+
+// event - Watcher event which applies the operation again
+func (operation failedOperation) event() watcher.Event {
+	switch operation.Operation {
+	case "remove":
+		return watcher.Event{Op: watcher.Remove, Path: operation.LocalPath}
+	case "rename":
+		return watcher.Event{Op: watcher.Rename, Path: operation.LocalPath, OldPath: operation.OldPath}
+	default:
+		return watcher.Event{Op: watcher.Write, Path: operation.LocalPath}
+	}
+}
+
+// isPermanent - Check if retrying can't help, like a permission denied or an operation the server doesn't support
+func isPermanent(err error) bool {
+	if errors.Is(err, fs.ErrPermission) || errors.Is(err, fs.ErrNotExist) {
+		return true
+	}
+
+	var statusErr *sftp.StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.FxCode() {
+		case sftp.ErrSSHFxPermissionDenied, sftp.ErrSSHFxNoSuchFile, sftp.ErrSSHFxOpUnsupported:
+			return true
+		}
+	}
+
+	return false
+}
+
+// withRetry - Run a remote operation, retrying transient failures with exponential backoff up to retryAttempts.
+// A dropped connection is re-established before the next attempt.
+func (session *syncSession) withRetry(description string, operation func() error) error {
+	var err error
+	delay := retryBaseDelay
+
+	for attempt := 1; ; attempt++ {
+		if session.Remote == nil {
+			err = errors.New("not connected")
+		} else {
+			err = operation()
+		}
+		if err == nil || isPermanent(err) || attempt == retryAttempts {
+			return err
+		}
+
+		fmt.Printf("[%s] Unable to %s (attempt %d/%d), retrying in %s: %s\n", session.PetName, description, attempt, retryAttempts, delay, err)
+		time.Sleep(delay)
+		delay = min(delay*2, retryMaxDelay)
+
+		if !session.alive() {
+			if reconnectErr := session.reconnect(); reconnectErr != nil {
+				fmt.Printf("[%s] Unable to reconnect: %s\n", session.PetName, reconnectErr)
+			}
+		}
+	}
+}
+
+// FailedAction - List, retry or drop the operations which couldn't be applied
+func (hosts HostConfig) FailedAction(action string, paths []string, jsonOutput bool) {
+	operations := hosts.Failed.selected(hosts.HostsMap, paths)
+
+	switch action {
+	case "list", "":
+		if jsonOutput {
+			data, _ := json.MarshalIndent(operations, "", "  ")
+			fmt.Println(string(data))
+			return
+		}
+		if len(operations) == 0 {
+			fmt.Println("No failed operations")
+			return
+		}
+		for _, operation := range operations {
+			kind := "transient"
+			if operation.Permanent {
+				kind = "permanent"
+			}
+			line := fmt.Sprintf("%s [%s] %s %s", operation.Time.Local().Format(time.DateTime), operation.Host, operation.Operation, operation.LocalPath)
+			if operation.OldPath != "" {
+				line += " from " + operation.OldPath
+			}
+			fmt.Printf("%s (%s, failed %d times): %s\n", line, kind, operation.Failures, operation.Error)
+		}
+	case "retry":
+		sessions := make(map[string]*syncSession)
+		failed := false
+		for _, operation := range operations {
+			session, ok := sessions[operation.Host]
+			if !ok {
+				var err error
+				if session, err = hosts.newSession(operation.Host); err != nil {
+					fmt.Printf("[%s] Encountered error while connecting: %s\n", operation.Host, err)
+				}
+				sessions[operation.Host] = session
+			}
+			if session == nil {
+				failed = true
+				continue
+			}
+
+			event := operation.event()
+			if err := session.handleEvent(event); err != nil {
+				hosts.Failed.add(operation.Host, event, err)
+				failed = true
+				continue
+			}
+			hosts.Failed.resolve(operation.Host, operation.LocalPath)
+			fmt.Printf("[%s] Applied %s of %s\n", operation.Host, operation.Operation, operation.LocalPath)
+		}
+		for _, session := range sessions {
+			if session != nil && session.Remote != nil {
+				session.Remote.Close()
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	case "drop":
+		for _, operation := range operations {
+			hosts.Failed.resolve(operation.Host, operation.LocalPath)
+		}
+		fmt.Printf("Dropped %d failed operations\n", len(operations))
+	default:
+		fmt.Println("Unknown failed action, supported are list, retry and drop")
+		os.Exit(1)
+	}
+}
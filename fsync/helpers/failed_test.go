@@ -0,0 +1,38 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pkg/sftp"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+)
+
+func TestIsPermanent(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"permission", fs.ErrPermission, true},
+		{"not exist", fs.ErrNotExist, true},
+		{"path error", &os.PathError{Op: "open", Path: "/src/main.go", Err: fs.ErrPermission}, true},
+		{"wrapped", fmt.Errorf("upload main.go: %w", fs.ErrNotExist), true},
+		{"sftp permission denied", &sftp.StatusError{Code: uint32(sftp.ErrSSHFxPermissionDenied)}, true},
+		{"sftp no such file", &sftp.StatusError{Code: uint32(sftp.ErrSSHFxNoSuchFile)}, true},
+		{"sftp unsupported", &sftp.StatusError{Code: uint32(sftp.ErrSSHFxOpUnsupported)}, true},
+		{"wrapped sftp", fmt.Errorf("rename: %w", &sftp.StatusError{Code: uint32(sftp.ErrSSHFxNoSuchFile)}), true},
+		{"sftp failure", &sftp.StatusError{Code: uint32(sftp.ErrSSHFxFailure)}, false},
+		{"connection lost", &sftp.StatusError{Code: uint32(sftp.ErrSSHFxConnectionLost)}, false},
+		{"eof", io.EOF, false},
+		{"generic", errors.New("connection reset by peer"), false},
+	}
+
+	for _, test := range tests {
+		if got := isPermanent(test.err); got != test.want {
+			t.Errorf("%s: isPermanent(%v) = %t, want %t", test.name, test.err, got, test.want)
+		}
+	}
+}
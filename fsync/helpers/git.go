@@ -0,0 +1,176 @@
+package helpers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/radovskyb/watcher"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// gitCommand - Run git inside LocalDir, the output of failed commands is turned into the error
+func (singleHost hostObject) gitCommand(stdin []byte, args ...string) ([]byte, error) {
+	command := exec.Command("git", append([]string{"-C", singleHost.LocalDir}, args...)...)
+	if stdin != nil {
+		command.Stdin = bytes.NewReader(stdin)
+	}
+
+	output, err := command.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return output, fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(string(exitErr.Stderr)))
+	}
+
+	return output, err
+}
+
+// splitNull - Split NUL terminated git output
+func splitNull(output []byte) []string {
+	var result []string
+	for _, item := range strings.Split(string(output), "\x00") {
+		if item != "" {
+			result = append(result, filepath.ToSlash(item))
+		}
+	}
+
+	return result
+}
+
+// gitHead - Commit currently checked out in LocalDir
+func (singleHost hostObject) gitHead() (string, error) {
+	output, err := singleHost.gitCommand(nil, "rev-parse", "HEAD")
+	return strings.TrimSpace(string(output)), err
+}
+
+// listGitTree - Tracked files and untracked files which git doesn't ignore, along with their directories
+func (singleHost hostObject) listGitTree() (map[string]treeEntry, error) {
+	entries := make(map[string]treeEntry)
+
+	output, err := singleHost.gitCommand(nil, "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, relPath := range splitNull(output) {
+		if _, exists := entries[relPath]; exists || singleHost.isIgnored(relPath) {
+			continue
+		}
+
+		// Files deleted in the working tree are still listed as tracked
+		info, err := os.Lstat(singleHost.localPath(relPath))
+		if err != nil || (!info.Mode().IsRegular() && !info.IsDir()) {
+			continue
+		}
+		entries[relPath] = treeEntry{relPath, info.Size(), info.ModTime(), info.Mode(), info.IsDir()}
+
+		for dir := path.Dir(relPath); dir != "."; dir = path.Dir(dir) {
+			if _, exists := entries[dir]; exists {
+				break
+			}
+			if info, err = os.Stat(singleHost.localPath(dir)); err == nil {
+				entries[dir] = treeEntry{dir, info.Size(), info.ModTime(), info.Mode(), true}
+			}
+		}
+	}
+
+	return entries, nil
+}
+
+// gitIgnored - Paths relative to LocalDir which git ignores, tracked files are never reported
+func (singleHost hostObject) gitIgnored(relPaths []string) (map[string]bool, error) {
+	ignored := make(map[string]bool)
+	if len(relPaths) == 0 {
+		return ignored, nil
+	}
+
+	output, err := singleHost.gitCommand([]byte(strings.Join(relPaths, "\x00")+"\x00"), "check-ignore", "-z", "--stdin")
+	var exitErr *exec.ExitError
+	// Exit code 1 means none of the paths are ignored
+	if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 1) {
+		return nil, err
+	}
+
+	for _, relPath := range splitNull(output) {
+		ignored[relPath] = true
+	}
+
+	return ignored, nil
+}
+
+// gitEvents - Reduce a batch to the paths git would ship. When HEAD moved since the last batch, the files which
+// differ between the two commits replace the events the checkout caused, so a branch switch is synced as one batch.
+func (session *syncSession) gitEvents(events []watcher.Event) []watcher.Event {
+	var result []watcher.Event
+	covered := make(map[string]bool)
+
+	head, err := session.Host.gitHead()
+	if err != nil {
+		fmt.Printf("[%s] Unable to read the git HEAD: %s\n", session.PetName, err)
+	} else if session.gitHead != "" && head != session.gitHead {
+		output, err := session.Host.gitCommand(nil, "diff", "--name-only", "-z", "--relative", session.gitHead, head)
+		if err != nil {
+			fmt.Printf("[%s] Unable to diff %s..%s: %s\n", session.PetName, session.gitHead, head, err)
+		} else {
+			changed := splitNull(output)
+			fmt.Printf("[%s] HEAD moved from %.8s to %.8s, syncing %d changed files\n", session.PetName, session.gitHead, head, len(changed))
+			for _, relPath := range changed {
+				localPath := session.Host.localPath(relPath)
+				covered[localPath] = true
+				if _, err := os.Lstat(localPath); err == nil {
+					result = append(result, watcher.Event{Op: watcher.Write, Path: localPath})
+				} else {
+					result = append(result, watcher.Event{Op: watcher.Remove, Path: localPath})
+				}
+			}
+		}
+	}
+	if err == nil {
+		session.gitHead = head
+	}
+
+	var relPaths []string
+	for _, event := range events {
+		for _, eventPath := range []string{event.Path, event.OldPath} {
+			if relPath, err := session.Host.relativePath(eventPath); eventPath != "" && err == nil {
+				relPaths = append(relPaths, relPath)
+			}
+		}
+	}
+	ignored, err := session.Host.gitIgnored(relPaths)
+	if err != nil {
+		fmt.Printf("[%s] Unable to check the git ignore rules: %s\n", session.PetName, err)
+		ignored = make(map[string]bool)
+	}
+	isGitIgnored := func(eventPath string) bool {
+		relPath, err := session.Host.relativePath(eventPath)
+		return err == nil && ignored[relPath]
+	}
+
+	for _, event := range events {
+		if covered[event.Path] {
+			continue
+		}
+
+		if isRenameEvent(event) {
+			oldIgnored, newIgnored := isGitIgnored(event.OldPath), isGitIgnored(event.Path)
+			switch {
+			case oldIgnored && newIgnored:
+				continue
+			case newIgnored:
+				event = watcher.Event{Op: watcher.Remove, Path: event.OldPath}
+			case oldIgnored:
+				event = watcher.Event{Op: watcher.Write, Path: event.Path}
+			}
+		} else if isGitIgnored(event.Path) {
+			continue
+		}
+
+		result = append(result, event)
+	}
+
+	return result
+}
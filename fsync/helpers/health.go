@@ -0,0 +1,210 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const healthInterval = 15 * time.Second
+
+// syncReady - Set once the initial sync of every host finished
+var syncReady atomic.Bool
+
+// sdNotify - Send a state change to systemd when running as a notify service, a no-op otherwise
+func sdNotify(state string) {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return
+	}
+	// Abstract sockets are passed with a leading @
+	if strings.HasPrefix(socketPath, "@") {
+		socketPath = "\x00" + socketPath[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		fmt.Println("Unable to notify systemd:", err)
+		return
+	}
+	defer conn.Close()
+
+	if _, err = conn.Write([]byte(state)); err != nil {
+		fmt.Println("Unable to notify systemd:", err)
+	}
+}
+
+// watchdogInterval - Half of the watchdog timeout systemd expects pings within, zero when the watchdog is off
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// markReady - Report readiness once the initial sync of every host finished
+func markReady(hostCount int) {
+	syncReady.Store(true)
+	sdNotify(fmt.Sprintf("READY=1\nSTATUS=Syncing %d hosts", hostCount))
+	fmt.Println("Initial sync of all hosts finished")
+}
+
+// handleShutdown - Exit cleanly on SIGINT or SIGTERM, telling systemd the stop was requested
+func handleShutdown() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	received := <-signals
+	stopDashboard()
+	sdNotify("STOPPING=1")
+	fmt.Printf("Received %s, stopping\n", received)
+	os.Exit(0)
+}
+
+// probe - Ask the queue of a host to check its connection. Skipped while the queue is full, the host is busy then.
+func (session *syncSession) probe() {
+	select {
+	case session.queue <- eventBatch{task: session.checkConnection}:
+	default:
+	}
+}
+
+// checkConnection - Reconnect when the connection stopped responding, run from the queue so it never races an operation.
+// The initial sync and the post-sync checks run from the queue as well.
+func (session *syncSession) checkConnection() {
+	if session.alive() {
+		return
+	}
+
+	if err := session.reconnect(); err != nil {
+		if !session.unreachable {
+			fmt.Printf("[%s] Host is unreachable: %s\n", session.PetName, err)
+		}
+		session.unreachable = true
+		return
+	}
+	if session.unreachable {
+		fmt.Printf("[%s] Host is reachable again\n", session.PetName)
+	}
+	session.unreachable = false
+}
+
+// unreachableFor - Shortest time any host has been unreachable, zero when at least one is connected
+func unreachableFor() time.Duration {
+	board.mutex.Lock()
+	defer board.mutex.Unlock()
+
+	var shortest time.Duration
+	for index, petName := range board.names {
+		since := board.hosts[petName].UnreachableSince
+		if since.IsZero() {
+			return 0
+		}
+		if elapsed := time.Since(since); index == 0 || elapsed < shortest {
+			shortest = elapsed
+		}
+	}
+
+	return shortest
+}
+
+// monitorHealth - Probe the hosts, ping the systemd watchdog and exit once every host was unreachable for longer than MaxUnreachable
+func (hosts HostConfig) monitorHealth(sessions []*syncSession) {
+	probes := time.NewTicker(healthInterval)
+	watchdog := make(<-chan time.Time)
+	if interval := watchdogInterval(); interval > 0 {
+		watchdog = time.NewTicker(interval).C
+	}
+
+	for {
+		select {
+		case <-probes.C:
+			for _, session := range sessions {
+				session.probe()
+			}
+
+			down := unreachableFor()
+			if hosts.MaxUnreachable > 0 && down > hosts.MaxUnreachable {
+				stopDashboard()
+				fmt.Printf("All hosts unreachable for over %s, exiting\n", hosts.MaxUnreachable)
+				sdNotify("STOPPING=1")
+				os.Exit(1)
+			}
+			if down > 0 {
+				sdNotify(fmt.Sprintf("STATUS=All hosts unreachable for %s", down.Round(time.Second)))
+			} else if syncReady.Load() {
+				sdNotify(fmt.Sprintf("STATUS=Syncing %d hosts", len(sessions)))
+			}
+		case <-watchdog:
+			sdNotify("WATCHDOG=1")
+		}
+	}
+}
+
+// healthReport - Body of /healthz
+type healthReport struct {
+	Status string                `json:"status"`
+	Hosts  map[string]hostHealth `json:"hosts"`
+}
+
+// hostHealth - State of a single host in the health report
+type hostHealth struct {
+	State            string     `json:"state"`
+	UnreachableSince *time.Time `json:"unreachable_since,omitempty"`
+	Queue            int        `json:"queue"`
+	Failed           int        `json:"failed"`
+}
+
+// ServeHealth - Serve /healthz on the given address in the background. It answers 503 until the initial sync
+// finished and while no host is reachable, and 200 otherwise, with the state of every host as JSON.
+func ServeHealth(address string) {
+	if address == "" {
+		return
+	}
+
+	listenAddr := serveHandler(address, "/healthz", http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		report := healthReport{Status: "ok", Hosts: make(map[string]hostHealth)}
+
+		board.mutex.Lock()
+		unreachable := 0
+		for _, petName := range board.names {
+			status := board.hosts[petName]
+			health := hostHealth{State: status.State, Queue: status.Queue, Failed: status.Failed}
+			if !status.UnreachableSince.IsZero() {
+				since := status.UnreachableSince.UTC()
+				health.UnreachableSince = &since
+				unreachable++
+			}
+			report.Hosts[petName] = health
+		}
+		board.mutex.Unlock()
+
+		code := http.StatusOK
+		switch {
+		case !syncReady.Load():
+			report.Status, code = "starting", http.StatusServiceUnavailable
+		case unreachable > 0 && unreachable == len(report.Hosts):
+			report.Status, code = "unavailable", http.StatusServiceUnavailable
+		case unreachable > 0:
+			report.Status = "degraded"
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(code)
+		json.NewEncoder(writer).Encode(report)
+	}))
+	fmt.Printf("Serving health checks on http://%s/healthz\n", listenAddr)
+}
@@ -4,30 +4,58 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/akamensky/argparse"
-	"github.com/pkg/sftp"
-	"github.com/radovskyb/watcher"
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/knownhosts"
 	"os"
-	"reflect"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 )
 
 const logFileName = "fsync.log"
+const stateDirName = ".fsync"
 
 type InputArgs struct {
-	Action     string
-	ConfigFile os.File
-	PublicKey  ssh.Signer
-	Hosts      ssh.HostKeyCallback
-	LogFile    os.File // Not in use yet
+	Action      string
+	Target      string
+	Host        string
+	Paths       []string
+	HostNames   []string
+	Groups      []string
+	Since       string
+	MetricsAddr string
+	HealthAddr  string
+	// MaxUnreachable - How long run keeps going while every host is unreachable, forever when zero
+	MaxUnreachable time.Duration
+	Delete         bool
+	DryRun         bool
+	JSON           bool
+	UI             bool
+	ConfigFile     os.File
+	PublicKey      ssh.Signer
+	Hosts          ssh.HostKeyCallback
+	// HostKeyAlgorithms - Algorithms of the keys known for a host, nil for unknown hosts
+	HostKeyAlgorithms func(address string) []string
+	LogFile           os.File // Not in use yet
+	StateDir          string
 }
 
 type HostConfig struct {
 	HostsMap map[string]hostObject
 	SSHKey   ssh.Signer
 	Hosts    ssh.HostKeyCallback
-	Logger   string // Not in use yet
+	// HostKeyAlgorithms - Algorithms of the keys known for a host, nil for unknown hosts
+	HostKeyAlgorithms func(address string) []string
+	Logger            string // Not in use yet
+	StateDir          string
+	Dashboard         bool
+	// MaxUnreachable - How long run keeps going while every host is unreachable, forever when zero
+	MaxUnreachable time.Duration
+	Uploads        *uploadState
+	Failed         *deadLetters
+	History        *historyLog
 }
 
 type hostObject struct {
@@ -36,23 +64,104 @@ type hostObject struct {
 	User      string `json:"user"`
 	LocalDir  string `json:"local_dir"`
 	RemoteDir string `json:"remote_dir"`
+
+	Ignore          []string `json:"ignore"`
+	Include         []string `json:"include"`
+	MaxFileSize     string   `json:"max_file_size"`
+	PreserveTimes   bool     `json:"preserve_times"`
+	PreserveMode    bool     `json:"preserve_mode"`
+	DeletePolicy    string   `json:"delete_policy"`
+	Groups          []string `json:"groups"`
+	Rollout         int      `json:"rollout"`
+	PostSyncCheck   string   `json:"post_sync_check"`
+	GitMode         bool     `json:"git_mode"`
+	Protocol        string   `json:"protocol"`
+	Verify          string   `json:"verify"`
+	Compress        bool     `json:"compress"`
+	TarThreshold    int      `json:"tar_threshold"`
+	CreateRemoteDir bool     `json:"create_remote_dir"`
+	MinFreeSpace    string   `json:"min_free_space"`
+	RemoteOwner     string   `json:"remote_owner"`
+	RemoteGroup     string   `json:"remote_group"`
+	FileMode        string   `json:"file_mode"`
+	DirMode         string   `json:"dir_mode"`
+
+	maxFileSize       int64
+	minFreeSpace      int64
+	fileMode          os.FileMode
+	dirMode           os.FileMode
+	remoteDirTemplate string
 }
 
 func ArgInit() InputArgs {
 	argParser := argparse.NewParser("fsync", "File synchronisation service for code editors")
 	selectedAction := argParser.StringPositional(&argparse.Options{Help: "Action which should be performed", Default: "run"})
+	selectedTarget := argParser.StringPositional(&argparse.Options{Help: "Pet name of the host for pull and verify or the first path for push"})
 	configFile := argParser.File("f", "file", os.O_RDWR, 0644, &argparse.Options{Required: true, Help: "Location of config file"})
-	sshKey := argParser.File("k", "key", os.O_RDONLY, 0644, &argparse.Options{Required: true, Help: "Location of the private key"})
-	hostsFile := argParser.File("j", "hosts", os.O_RDONLY, 0644, &argparse.Options{Required: true, Help: "Location of the hosts file"})
+	sshKey := argParser.String("k", "key", &argparse.Options{Required: true, Help: "Location of the private key"})
+	hostsFile := argParser.String("j", "hosts", &argparse.Options{Required: true, Help: "Location of the hosts file"})
+	selectedHost := argParser.StringPositional(&argparse.Options{Help: "Pet name of the host for trash"})
+	extraPaths := argParser.StringList("p", "path", &argparse.Options{Help: "Additional path for push, trash item to restore or purge, or path to filter the history by. Can be repeated"})
+	hostNames := argParser.StringList("", "host", &argparse.Options{Help: "Only use this host for run, verify and push, or only show its history. Can be repeated"})
+	groups := argParser.StringList("", "group", &argparse.Options{Help: "Only use the hosts of this group for run, verify and push, can be repeated"})
+	since := argParser.String("", "since", &argparse.Options{Help: "Only show history since a duration ago (12h, 7d), a date or an RFC 3339 timestamp"})
+	deleteExtra := argParser.Flag("", "delete", &argparse.Options{Help: "Delete local files which don't exist on the remote when pulling"})
+	dryRun := argParser.Flag("", "dry-run", &argparse.Options{Help: "Only show the changes which would be made"})
+	jsonOutput := argParser.Flag("", "json", &argparse.Options{Help: "Print the verify report or the history as JSON"})
+	acceptNew := argParser.Flag("", "accept-new", &argparse.Options{Help: "Show the fingerprint of unknown hosts and add them to the hosts file"})
+	dashboard := argParser.Flag("", "ui", &argparse.Options{Help: "Show a live dashboard of the hosts while running, plain logs are kept when stdout isn't a terminal"})
+	metricsAddr := argParser.String("", "metrics-addr", &argparse.Options{Help: "Serve Prometheus metrics on /metrics of this address while running, for example 127.0.0.1:9273"})
+	healthAddr := argParser.String("", "health-addr", &argparse.Options{Help: "Serve a health check on /healthz of this address while running, can be the same as --metrics-addr"})
+	maxUnreachable := argParser.String("", "max-unreachable", &argparse.Options{Help: "Exit with an error once every host was unreachable for this long while running, for example 10m"})
+	stateDir := argParser.String("s", "state", &argparse.Options{Required: false, Help: "Location of the directory for sync state", Default: defaultStateDir()})
 	logFile := argParser.File("l", "log", os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644, &argparse.Options{Required: false, Help: "Location of file for logging", Default: logFileName})
 
+	// argparse has no variadic positionals, so push gets one for every argument which could be a further path
+	var trailingPaths []*string
+	if len(os.Args) > 1 && os.Args[1] == "push" {
+		for range os.Args[2:] {
+			trailingPaths = append(trailingPaths, argParser.StringPositional(&argparse.Options{Help: "Further path for push"}))
+		}
+	}
+
 	err := argParser.Parse(os.Args)
 	if err != nil {
 		fmt.Print(argParser.Usage(err))
 		os.Exit(1)
 	}
 
-	keyData, err := os.ReadFile(sshKey.Name())
+	paths := *extraPaths
+	if *selectedAction == "push" {
+		var positionalPaths []string
+		for _, value := range append([]*string{selectedTarget, selectedHost}, trailingPaths...) {
+			if *value != "" {
+				positionalPaths = append(positionalPaths, *value)
+			}
+		}
+		paths = append(positionalPaths, paths...)
+	} else if *selectedHost != "" && *selectedAction != "trash" {
+		fmt.Print(argParser.Usage("unknown arguments " + *selectedHost))
+		os.Exit(1)
+	}
+
+	var maxUnreachableDuration time.Duration
+	if *maxUnreachable != "" {
+		maxUnreachableDuration, err = time.ParseDuration(*maxUnreachable)
+		if err != nil || maxUnreachableDuration <= 0 {
+			fmt.Printf("Invalid --max-unreachable %q, use a duration like 10m\n", *maxUnreachable)
+			os.Exit(1)
+		}
+	}
+
+	for _, location := range []*string{sshKey, hostsFile, stateDir} {
+		*location, err = expandLocalPath(*location)
+		if err != nil {
+			fmt.Println("Error expanding path:", err)
+			os.Exit(1)
+		}
+	}
+
+	keyData, err := os.ReadFile(*sshKey)
 	if err != nil {
 		fmt.Println("Error reading private key:", err)
 		os.Exit(1)
@@ -64,13 +173,49 @@ func ArgInit() InputArgs {
 		os.Exit(1)
 	}
 
-	hostsData, err := knownhosts.New(hostsFile.Name())
+	hostsData, err := loadHostKeys(*hostsFile, *acceptNew)
 	if err != nil {
 		fmt.Println("Error parsing hosts file:", err)
 		os.Exit(1)
 	}
 
-	return InputArgs{*selectedAction, *configFile, privateKey, hostsData, *logFile}
+	err = os.MkdirAll(*stateDir, 0700)
+	if err != nil {
+		fmt.Println("Error creating state directory:", err)
+		os.Exit(1)
+	}
+
+	return InputArgs{
+		Action:            *selectedAction,
+		Target:            *selectedTarget,
+		Host:              *selectedHost,
+		Paths:             paths,
+		HostNames:         *hostNames,
+		Groups:            *groups,
+		Since:             *since,
+		MetricsAddr:       *metricsAddr,
+		HealthAddr:        *healthAddr,
+		MaxUnreachable:    maxUnreachableDuration,
+		Delete:            *deleteExtra,
+		DryRun:            *dryRun,
+		JSON:              *jsonOutput,
+		UI:                *dashboard,
+		ConfigFile:        *configFile,
+		PublicKey:         privateKey,
+		Hosts:             hostsData.Callback,
+		HostKeyAlgorithms: hostsData.Algorithms,
+		LogFile:           *logFile,
+		StateDir:          *stateDir,
+	}
+}
+
+func defaultStateDir() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return stateDirName
+	}
+
+	return filepath.Join(homeDir, stateDirName)
 }
 
 func BuildHostConfig(i InputArgs) HostConfig {
@@ -88,10 +233,37 @@ func BuildHostConfig(i InputArgs) HostConfig {
 		os.Exit(1)
 	}
 
+	switch i.Action {
+	case "run", "verify", "push", "failed":
+		hosts.HostsMap, err = selectHosts(hosts.HostsMap, i.HostNames, i.Groups)
+		if err != nil {
+			fmt.Println("Encountered error while selecting hosts:", err)
+			os.Exit(1)
+		}
+	}
+
 	for key, value := range hosts.HostsMap {
+		value, err = value.expandHostConfig()
+		if err != nil {
+			fmt.Printf("[%s] Invalid %s\n", key, err)
+			os.Exit(1)
+		}
+		value.LocalDir = filepath.Clean(value.LocalDir)
+		if strings.Contains(value.RemoteDir, "{{") {
+			value.remoteDirTemplate = value.RemoteDir
+			value.RemoteDir, err = value.renderRemoteDir()
+			if err != nil {
+				fmt.Printf("[%s] Invalid remote_dir: %s\n", key, err)
+				os.Exit(1)
+			}
+		}
+		value.RemoteDir = path.Clean(value.RemoteDir)
+		hosts.HostsMap[key] = value
+
 		pwd, err := os.ReadDir(value.LocalDir)
 		_ = pwd
-		if err != nil {
+		// Pull is used to bootstrap the local directory, so it may not exist yet
+		if err != nil && !(i.Action == "pull" && os.IsNotExist(err)) {
 			fmt.Println("Error reading local directory:", err)
 			os.Exit(1)
 		}
@@ -101,88 +273,152 @@ func BuildHostConfig(i InputArgs) HostConfig {
 
 			hosts.HostsMap[key] = value
 		}
-	}
-
-	hosts.SSHKey = i.PublicKey
-	hosts.Hosts = i.Hosts
-	hosts.Logger = i.LogFile.Name()
 
-	return hosts
-}
+		switch value.DeletePolicy {
+		case "":
+			value.DeletePolicy = DeletePolicyDelete
+			hosts.HostsMap[key] = value
+		case DeletePolicyDelete, DeletePolicyTrash, DeletePolicyIgnore:
+		default:
+			fmt.Printf("[%s] Unknown delete_policy %s, supported are delete, trash and ignore\n", key, value.DeletePolicy)
+			os.Exit(1)
+		}
 
-func (hosts HostConfig) VerifyHosts() {
-	for hostPetName, hostData := range hosts.HostsMap {
-		fmt.Printf("[%s] Starting verification\n", hostPetName)
+		switch value.Verify {
+		case "":
+			value.Verify = VerifyHash
+		case VerifyNone, VerifySize, VerifyHash:
+		default:
+			fmt.Printf("[%s] Unknown verify %s, supported are none, size and hash\n", key, value.Verify)
+			os.Exit(1)
+		}
 
-		sshConfig := &ssh.ClientConfig{
-			User: hostData.User,
-			Auth: []ssh.AuthMethod{
-				ssh.PublicKeys(hosts.SSHKey),
-			},
-			HostKeyCallback: hosts.Hosts,
+		switch value.Protocol {
+		case "":
+			value.Protocol = ProtocolAuto
+		case ProtocolAuto, ProtocolSFTP, ProtocolSCP, ProtocolShell:
+		default:
+			fmt.Printf("[%s] Unknown protocol %s, supported are auto, sftp, scp and shell\n", key, value.Protocol)
+			os.Exit(1)
 		}
 
-		conn, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", hostData.Hostname, hostData.Port), sshConfig)
-		if err != nil {
-			fmt.Println("Encountered error trying to connect over ssh:", err)
+		if value.fileMode, err = parseMode(value.FileMode); err != nil {
+			fmt.Printf("[%s] Invalid file_mode: %s\n", key, err)
+			os.Exit(1)
+		}
+		if value.dirMode, err = parseMode(value.DirMode); err != nil {
+			fmt.Printf("[%s] Invalid dir_mode: %s\n", key, err)
 			os.Exit(1)
 		}
 
-		client, err := sftp.NewClient(conn)
+		value.maxFileSize, err = parseSize(value.MaxFileSize)
 		if err != nil {
-			fmt.Println("Encountered error while trying to create client:", err)
+			fmt.Printf("[%s] Invalid max_file_size: %s\n", key, err)
 			os.Exit(1)
 		}
-
-		defer client.Close()
-		pwd, err := client.Getwd()
-		_ = pwd
+		value.minFreeSpace, err = parseSize(value.MinFreeSpace)
 		if err != nil {
-			fmt.Println("Encountered error while reading directory:", err)
+			fmt.Printf("[%s] Invalid min_free_space: %s\n", key, err)
 			os.Exit(1)
-		} else {
-			fmt.Printf("[%s] Verification succesfull\n", hostPetName)
 		}
+		hosts.HostsMap[key] = value
 	}
-}
 
-func (hosts HostConfig) StartSync() {
-	var waitGroup sync.WaitGroup
+	hosts.SSHKey = i.PublicKey
+	hosts.Hosts = i.Hosts
+	hosts.HostKeyAlgorithms = i.HostKeyAlgorithms
+	hosts.Logger = i.LogFile.Name()
+	hosts.StateDir = i.StateDir
+	hosts.Dashboard = i.UI
+	hosts.MaxUnreachable = i.MaxUnreachable
 
-	fmt.Println("Sync started")
-	for hostPetName, hostData := range hosts.HostsMap {
-		fmt.Printf("[%s] Starting sync\n", hostPetName)
-		go hostData.syncContent(hostPetName)
-		waitGroup.Add(1)
+	hosts.History = newHistoryLog(i.StateDir)
+	hosts.Uploads, err = loadUploadState(i.StateDir)
+	if err != nil {
+		fmt.Println("Encountered error while reading upload state:", err)
+		os.Exit(1)
 	}
-	waitGroup.Wait()
+	hosts.Failed, err = loadDeadLetters(i.StateDir)
+	if err != nil {
+		fmt.Println("Encountered error while reading failed operations:", err)
+		os.Exit(1)
+	}
+
+	return hosts
 }
 
-func (singleHost hostObject) syncContent(petName string) {
-	fmt.Printf("[%s] Monitoring: %s\n", petName, singleHost.LocalDir)
-	watcherObject := watcher.New()
+// selectHosts - Keep the hosts which are named or belong to one of the groups, all of them when nothing is selected
+func selectHosts(hostsMap map[string]hostObject, hostNames []string, groups []string) (map[string]hostObject, error) {
+	if len(hostNames) == 0 && len(groups) == 0 {
+		return hostsMap, nil
+	}
 
-	go func() {
-		for {
-			select {
-			case event := <-watcherObject.Event:
-				fmt.Printf("Op: %s, Path: %s, Type: %s, StringValue: %s\n", event.Op, event.Path, reflect.TypeOf(event.FileInfo), event.String())
-			case err := <-watcherObject.Error:
-				fmt.Println("Encountered error while goroutine is running:", err)
-			case <-watcherObject.Closed:
-				return
+	selected := make(map[string]hostObject)
+	for _, petName := range hostNames {
+		hostData, ok := hostsMap[petName]
+		if !ok {
+			return nil, fmt.Errorf("host %s isn't present in the config", petName)
+		}
+		selected[petName] = hostData
+	}
+
+	for _, group := range groups {
+		found := false
+		for petName, hostData := range hostsMap {
+			if slices.Contains(hostData.Groups, group) {
+				selected[petName] = hostData
+				found = true
 			}
 		}
-	}()
+		if !found {
+			return nil, fmt.Errorf("no host belongs to group %s", group)
+		}
+	}
 
-	err := watcherObject.AddRecursive(singleHost.LocalDir)
-	if err != nil {
-		fmt.Println("Encountered error while trying to add file:", err)
-		os.Exit(1)
+	return selected, nil
+}
+
+func (hosts HostConfig) StartSync() {
+	var (
+		waitGroup sync.WaitGroup
+		sessions  []*syncSession
+	)
+
+	fmt.Println("Sync started")
+	for hostPetName := range hosts.HostsMap {
+		fmt.Printf("[%s] Starting sync\n", hostPetName)
+		session, err := hosts.newSession(hostPetName)
+		if err != nil {
+			fmt.Printf("[%s] Encountered error while connecting: %s\n", hostPetName, err)
+			os.Exit(1)
+		}
+		sessions = append(sessions, session)
 	}
 
-	err = watcherObject.Start(1000)
-	if err != nil {
-		fmt.Println("Encountered error while starting watcher:", err)
+	if hosts.Dashboard {
+		startDashboard()
+	}
+	go handleShutdown()
+
+	// Hosts sharing a local directory share a single watcher
+	var ready sync.WaitGroup
+	for _, group := range buildWatchGroups(sessions) {
+		waitGroup.Add(1)
+		ready.Add(1)
+		go func(group *watchGroup) {
+			defer waitGroup.Done()
+			group.watch(&ready)
+		}(group)
 	}
+	go func() {
+		ready.Wait()
+		markReady(len(sessions))
+	}()
+	go hosts.monitorHealth(sessions)
+
+	waitGroup.Wait()
+	stopDashboard()
+	fmt.Println("All watchers stopped, exiting")
+	sdNotify("STOPPING=1")
+	os.Exit(1)
 }
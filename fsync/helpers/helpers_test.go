@@ -0,0 +1,49 @@
+package helpers
+
+import (
+	"slices"
+	"sort"
+	"testing"
+)
+
+func TestSelectHosts(t *testing.T) {
+	hostsMap := map[string]hostObject{
+		"web1": {Groups: []string{"web", "prod"}},
+		"web2": {Groups: []string{"web"}},
+		"db1":  {Groups: []string{"db", "prod"}},
+		"dev":  {},
+	}
+
+	tests := []struct {
+		hostNames []string
+		groups    []string
+		want      []string
+		wantErr   bool
+	}{
+		{nil, nil, []string{"db1", "dev", "web1", "web2"}, false},
+		{[]string{"dev"}, nil, []string{"dev"}, false},
+		{nil, []string{"web"}, []string{"web1", "web2"}, false},
+		{nil, []string{"web", "db"}, []string{"db1", "web1", "web2"}, false},
+		{[]string{"dev"}, []string{"prod"}, []string{"db1", "dev", "web1"}, false},
+		{[]string{"web1"}, []string{"web"}, []string{"web1", "web2"}, false},
+		{[]string{"missing"}, nil, nil, true},
+		{nil, []string{"staging"}, nil, true},
+	}
+
+	for _, test := range tests {
+		selected, err := selectHosts(hostsMap, test.hostNames, test.groups)
+		if (err != nil) != test.wantErr {
+			t.Errorf("selectHosts(%q, %q) error %v, want error %t", test.hostNames, test.groups, err, test.wantErr)
+			continue
+		}
+
+		var got []string
+		for petName := range selected {
+			got = append(got, petName)
+		}
+		sort.Strings(got)
+		if !slices.Equal(got, test.want) {
+			t.Errorf("selectHosts(%q, %q) = %q, want %q", test.hostNames, test.groups, got, test.want)
+		}
+	}
+}
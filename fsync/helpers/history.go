@@ -0,0 +1,235 @@
+package helpers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const historyFileName = "history.jsonl"
+
+// historyEntry - Single remote mutation in the audit trail
+type historyEntry struct {
+	Time       time.Time `json:"time"`
+	Host       string    `json:"host"`
+	Operation  string    `json:"operation"`
+	LocalPath  string    `json:"local_path,omitempty"`
+	RemotePath string    `json:"remote_path"`
+	Bytes      int64     `json:"bytes"`
+	DurationMs int64     `json:"duration_ms"`
+	Checksum   string    `json:"checksum,omitempty"`
+	Result     string    `json:"result"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// historyLog - Append only JSON lines file in the state directory
+type historyLog struct {
+	path  string
+	mutex sync.Mutex
+}
+
+// historyFilter - Conditions selected on the command line for the history action
+type historyFilter struct {
+	Hosts []string
+	Since time.Time
+	Paths []string
+}
+
+// newHistoryLog - History file inside the state directory
+func newHistoryLog(stateDir string) *historyLog {
+	return &historyLog{path: filepath.Join(stateDir, historyFileName)}
+}
+
+// record - Append an entry, failures are only reported since the mutation itself already happened
+func (history *historyLog) record(entry historyEntry) {
+	if history == nil {
+		return
+	}
+
+	if entry.Result == "" {
+		entry.Result = "ok"
+		if entry.Error != "" {
+			entry.Result = "failed"
+		}
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		fmt.Println("Unable to encode history entry:", err)
+		return
+	}
+
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	fileObject, err := os.OpenFile(history.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		fmt.Println("Unable to open history file:", err)
+		return
+	}
+	defer fileObject.Close()
+
+	if _, err = fileObject.Write(append(data, '\n')); err != nil {
+		fmt.Println("Unable to write history file:", err)
+	}
+}
+
+// record - Append a mutation of this host to the history
+func (session *syncSession) record(operation string, relPath string, remotePath string, bytes int64, start time.Time, checksum string, err error) {
+	entry := historyEntry{
+		Time:       start.UTC(),
+		Host:       session.PetName,
+		Operation:  operation,
+		RemotePath: remotePath,
+		Bytes:      bytes,
+		DurationMs: time.Since(start).Milliseconds(),
+		Checksum:   checksum,
+	}
+	if relPath != "" {
+		entry.LocalPath = session.Host.localPath(relPath)
+	}
+	entry.Error = errorString(err)
+
+	// Every remote mutation passes through here, so the metrics and the dashboard are updated along with the history
+	if err != nil {
+		operationFailures.WithLabelValues(session.PetName, operation).Inc()
+		board.update(session.PetName, func(status *hostStatus) {
+			status.Failed++
+			status.LastError = fmt.Sprintf("%s %s: %s", operation, remotePath, err)
+		})
+	} else if operation == "upload" {
+		filesUploaded.WithLabelValues(session.PetName).Inc()
+		bytesUploaded.WithLabelValues(session.PetName).Add(float64(bytes))
+		uploadDuration.WithLabelValues(session.PetName).Observe(time.Since(start).Seconds())
+		board.update(session.PetName, func(status *hostStatus) { status.Uploaded++ })
+	}
+
+	session.Config.History.record(entry)
+}
+
+// errorString - Message of an error, empty for nil
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
+}
+
+// parseSince - Accept a duration such as 90m, 12h or 7d, a date or an RFC 3339 timestamp
+func parseSince(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-duration), nil
+	}
+	if days, found := strings.CutSuffix(value, "d"); found {
+		if count, err := strconv.Atoi(days); err == nil {
+			return time.Now().AddDate(0, 0, -count), nil
+		}
+	}
+	if timestamp, err := time.Parse(time.RFC3339, value); err == nil {
+		return timestamp, nil
+	}
+	if date, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		return date, nil
+	}
+
+	return time.Time{}, fmt.Errorf("unable to parse %q, use a duration like 12h or 7d, a date or an RFC 3339 timestamp", value)
+}
+
+// matches - Check an entry against the filter
+func (filter historyFilter) matches(entry historyEntry) bool {
+	if len(filter.Hosts) > 0 && !slices.Contains(filter.Hosts, entry.Host) {
+		return false
+	}
+	if entry.Time.Before(filter.Since) {
+		return false
+	}
+	if len(filter.Paths) == 0 {
+		return true
+	}
+
+	return pathSelected(filter.Paths, entry.LocalPath, entry.RemotePath)
+}
+
+// pathSelected - Check if one of the paths is at or below one of the selected paths, relative ones are also tried from the working directory
+func pathSelected(selectedPaths []string, paths ...string) bool {
+	for _, selectedPath := range selectedPaths {
+		candidates := []string{selectedPath}
+		if absPath, err := filepath.Abs(selectedPath); err == nil {
+			candidates = append(candidates, absPath)
+		}
+		for _, candidate := range candidates {
+			candidate = strings.TrimSuffix(candidate, "/")
+			for _, entryPath := range paths {
+				if entryPath != "" && (entryPath == candidate || strings.HasPrefix(entryPath, candidate+"/")) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// ShowHistory - Print the recorded remote mutations which match the filter
+func ShowHistory(stateDir string, hostNames []string, since string, paths []string, jsonOutput bool) {
+	sinceTime, err := parseSince(since)
+	if err != nil {
+		fmt.Println("Invalid --since:", err)
+		os.Exit(1)
+	}
+	filter := historyFilter{Hosts: hostNames, Since: sinceTime, Paths: paths}
+
+	fileObject, err := os.Open(filepath.Join(stateDir, historyFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Println("No history recorded yet")
+			return
+		}
+		fmt.Println("Unable to open history file:", err)
+		os.Exit(1)
+	}
+	defer fileObject.Close()
+
+	scanner := bufio.NewScanner(fileObject)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry historyEntry
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil || !filter.matches(entry) {
+			continue
+		}
+
+		if jsonOutput {
+			fmt.Println(scanner.Text())
+			continue
+		}
+
+		line := fmt.Sprintf("%s [%s] %s %s", entry.Time.Local().Format(time.DateTime), entry.Host, entry.Operation, entry.Result)
+		if entry.LocalPath != "" {
+			line += fmt.Sprintf(" %s ->", entry.LocalPath)
+		}
+		line += fmt.Sprintf(" %s (%d bytes, %dms)", entry.RemotePath, entry.Bytes, entry.DurationMs)
+		if entry.Checksum != "" {
+			line += " sha256:" + entry.Checksum
+		}
+		if entry.Error != "" {
+			line += ": " + entry.Error
+		}
+		fmt.Println(line)
+	}
+
+	if err = scanner.Err(); err != nil {
+		fmt.Println("Unable to read history file:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,71 @@
+package helpers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"", time.Time{}},
+		{"90m", now.Add(-90 * time.Minute)},
+		{"12h", now.Add(-12 * time.Hour)},
+		{"7d", now.AddDate(0, 0, -7)},
+		{"2024-05-01", time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)},
+		{"2024-05-01T10:30:00Z", time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		got, err := parseSince(test.value)
+		if err != nil {
+			t.Errorf("parseSince(%q) failed: %s", test.value, err)
+			continue
+		}
+		// Relative values depend on the time of the call
+		if diff := got.Sub(test.want); diff < -time.Minute || diff > time.Minute {
+			t.Errorf("parseSince(%q) = %s, want %s", test.value, got, test.want)
+		}
+	}
+
+	for _, value := range []string{"yesterday", "7w", "d", "2024-13-01"} {
+		if _, err := parseSince(value); err == nil {
+			t.Errorf("parseSince(%q) didn't fail", value)
+		}
+	}
+}
+
+func TestPathSelected(t *testing.T) {
+	workDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		selected []string
+		paths    []string
+		want     bool
+	}{
+		{[]string{"/src"}, []string{"/src"}, true},
+		{[]string{"/src"}, []string{"/src/main.go"}, true},
+		{[]string{"/src/"}, []string{"/src/main.go"}, true},
+		{[]string{"/src"}, []string{"/src-old/main.go"}, false},
+		{[]string{"/src"}, []string{"", "/srv/app/main.go"}, false},
+		{[]string{"/srv/app"}, []string{"/src/main.go", "/srv/app/main.go"}, true},
+		{[]string{"/docs", "/src"}, []string{"/src/main.go"}, true},
+		{[]string{"pkg"}, []string{filepath.Join(workDir, "pkg", "a.go")}, true},
+		{[]string{"pkg"}, []string{"pkg/a.go"}, true},
+		{[]string{"pkg"}, []string{filepath.Join(workDir, "other", "a.go")}, false},
+	}
+
+	for _, test := range tests {
+		if got := pathSelected(test.selected, test.paths...); got != test.want {
+			t.Errorf("pathSelected(%q, %q) = %t, want %t", test.selected, test.paths, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// hostKeyStore - Known hosts file with an optional trust-on-first-use policy
+type hostKeyStore struct {
+	path      string
+	acceptNew bool
+	callback  ssh.HostKeyCallback
+	mutex     sync.Mutex
+}
+
+// loadHostKeys - Read the known hosts file, creating it when new hosts are accepted
+func loadHostKeys(path string, acceptNew bool) (*hostKeyStore, error) {
+	if _, err := os.Stat(path); err != nil {
+		if !os.IsNotExist(err) || !acceptNew {
+			return nil, err
+		}
+
+		fileObject, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		fileObject.Close()
+	}
+
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &hostKeyStore{path: path, acceptNew: acceptNew, callback: callback}, nil
+}
+
+// Callback - Verify the host key, appending unknown keys when accept-new is enabled
+func (store *hostKeyStore) Callback(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	err := store.callback(hostname, remote, key)
+	if err == nil {
+		return nil
+	}
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		return fmt.Errorf("host key for %s rejected: %w", hostname, err)
+	}
+
+	fingerprint := ssh.FingerprintSHA256(key)
+	if len(keyErr.Want) > 0 {
+		var knownLines []string
+		for _, known := range keyErr.Want {
+			knownLines = append(knownLines, fmt.Sprintf("%s (%s:%d)", ssh.FingerprintSHA256(known.Key), known.Filename, known.Line))
+		}
+
+		return fmt.Errorf("host key for %s has changed: got %s %s, expected %s. Remove the old entry from %s if the change is legitimate", hostname, key.Type(), fingerprint, strings.Join(knownLines, ", "), store.path)
+	}
+
+	if !store.acceptNew {
+		return fmt.Errorf("host %s is not in %s (%s %s). Rerun with --accept-new to trust it", hostname, store.path, key.Type(), fingerprint)
+	}
+
+	fmt.Printf("Adding new host key for %s: %s %s\n", hostname, key.Type(), fingerprint)
+	fileObject, err := os.OpenFile(store.path, os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %w", store.path, err)
+	}
+	defer fileObject.Close()
+
+	line := knownhosts.Line(knownHostAddresses(hostname, remote), key) + "\n"
+	if info, err := fileObject.Stat(); err == nil && info.Size() > 0 {
+		lastByte := make([]byte, 1)
+		if _, err = fileObject.ReadAt(lastByte, info.Size()-1); err == nil && lastByte[0] != '\n' {
+			line = "\n" + line
+		}
+	}
+
+	if _, err = fileObject.WriteString(line); err != nil {
+		return fmt.Errorf("unable to write to %s: %w", store.path, err)
+	}
+
+	store.callback, err = knownhosts.New(store.path)
+	if err != nil {
+		return fmt.Errorf("unable to reload %s: %w", store.path, err)
+	}
+
+	return nil
+}
+
+// hostKeyPreference - Host key algorithms in the order they are offered to the server
+var hostKeyPreference = []string{
+	ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521, ssh.KeyAlgoSKED25519,
+	ssh.KeyAlgoSKECDSA256, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA, ssh.KeyAlgoDSA,
+}
+
+// unknownKey - Public key no known hosts entry can match, used to list the known keys of a host
+type unknownKey struct{}
+
+func (unknownKey) Type() string                        { return "fsync-unknown" }
+func (unknownKey) Marshal() []byte                     { return []byte("fsync-unknown") }
+func (unknownKey) Verify([]byte, *ssh.Signature) error { return errors.New("not a key") }
+
+// Algorithms - Host key algorithms matching the keys known for an address in host:port form, nil when the host is
+// unknown so any key can be accepted. RSA keys allow the SHA-2 signature algorithms as well.
+func (store *hostKeyStore) Algorithms(address string) []string {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(store.callback(address, &net.TCPAddr{IP: net.IPv4zero}, unknownKey{}), &keyErr) || len(keyErr.Want) == 0 {
+		return nil
+	}
+
+	known := make(map[string]bool)
+	for _, knownKey := range keyErr.Want {
+		known[knownKey.Key.Type()] = true
+	}
+
+	var algorithms []string
+	for _, algorithm := range hostKeyPreference {
+		keyType := algorithm
+		if algorithm == ssh.KeyAlgoRSASHA512 || algorithm == ssh.KeyAlgoRSASHA256 {
+			keyType = ssh.KeyAlgoRSA
+		}
+		if known[keyType] {
+			algorithms = append(algorithms, algorithm)
+		}
+	}
+
+	return algorithms
+}
+
+// knownHostAddresses - Known hosts entries for a host, including the resolved IP when it differs
+func knownHostAddresses(hostname string, remote net.Addr) []string {
+	result := []string{knownhosts.Normalize(hostname)}
+	if remote != nil && knownhosts.Normalize(remote.String()) != result[0] {
+		result = append(result, knownhosts.Normalize(remote.String()))
+	}
+
+	return result
+}
@@ -0,0 +1,61 @@
+package helpers
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestHostKeyAlgorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	var keys []ssh.PublicKey
+	for _, key := range []interface{}{&rsaKey.PublicKey, edKey.Public(), &ecKey.PublicKey} {
+		publicKey, err := ssh.NewPublicKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, publicKey)
+	}
+
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	lines := knownhosts.Line([]string{"rsa.example"}, keys[0]) + "\n" +
+		knownhosts.Line([]string{"[both.example]:2222"}, keys[2]) + "\n" +
+		knownhosts.Line([]string{"[both.example]:2222"}, keys[1]) + "\n"
+	if err = os.WriteFile(knownHostsPath, []byte(lines), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := loadHostKeys(knownHostsPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		address string
+		want    []string
+	}{
+		{"rsa.example:22", []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}},
+		{"both.example:2222", []string{ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256}},
+		{"both.example:22", nil},
+		{"unknown.example:22", nil},
+	}
+
+	for _, test := range tests {
+		if got := store.Algorithms(test.address); !slices.Equal(got, test.want) {
+			t.Errorf("Algorithms(%q) = %v, want %v", test.address, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package helpers
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net"
+	"net/http"
+	"os"
+)
+
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	eventsSeen = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fsync_events_total",
+		Help: "Watcher events delivered to the host",
+	}, []string{"host"})
+	filesUploaded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fsync_uploaded_files_total",
+		Help: "Files uploaded to the host",
+	}, []string{"host"})
+	bytesUploaded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fsync_uploaded_bytes_total",
+		Help: "Bytes uploaded to the host",
+	}, []string{"host"})
+	operationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fsync_failures_total",
+		Help: "Remote operations which failed",
+	}, []string{"host", "operation"})
+	reconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fsync_reconnects_total",
+		Help: "Times the connection to the host was re-established",
+	}, []string{"host"})
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fsync_queue_depth",
+		Help: "Events waiting to be applied to the host",
+	}, []string{"host"})
+	lastSuccessfulSync = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fsync_last_successful_sync_timestamp_seconds",
+		Help: "Unix time of the last sync which was applied to the host without errors",
+	}, []string{"host"})
+	uploadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "fsync_upload_duration_seconds",
+		Help:    "Time taken to upload and verify a single file",
+		Buckets: prometheus.ExponentialBuckets(0.01, 4, 8),
+	}, []string{"host"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(eventsSeen, filesUploaded, bytesUploaded, operationFailures, reconnects, queueDepth, lastSuccessfulSync, uploadDuration)
+}
+
+// ServeMetrics - Expose the metrics on /metrics of the given address in the background
+func ServeMetrics(address string) {
+	if address == "" {
+		return
+	}
+
+	listenAddr := serveHandler(address, "/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	fmt.Printf("Serving metrics on http://%s/metrics\n", listenAddr)
+}
+
+// httpServers - Muxes of the addresses served so far, so several endpoints can share one address
+var httpServers = make(map[string]*http.ServeMux)
+var httpAddrs = make(map[string]net.Addr)
+
+// serveHandler - Add a handler to the server of the given address, starting it in the background on first use.
+// The address actually listened on is returned.
+func serveHandler(address string, pattern string, handler http.Handler) net.Addr {
+	if mux, ok := httpServers[address]; ok {
+		mux.Handle(pattern, handler)
+		return httpAddrs[address]
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(pattern, handler)
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		fmt.Printf("Encountered error while serving %s: %s\n", pattern, err)
+		os.Exit(1)
+	}
+	httpServers[address], httpAddrs[address] = mux, listener.Addr()
+
+	go func() {
+		err := http.Serve(listener, mux)
+		if err != nil {
+			fmt.Println("Encountered error while serving http:", err)
+		}
+	}()
+
+	return listener.Addr()
+}
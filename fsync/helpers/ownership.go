@@ -0,0 +1,128 @@
+package helpers
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// parseMode - Parse an octal file_mode or dir_mode like 0640, including the setuid, setgid and sticky bits
+func parseMode(value string) (os.FileMode, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	rawMode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || rawMode > 07777 {
+		return 0, fmt.Errorf("invalid mode %q, use octal permissions like 0644", value)
+	}
+
+	return os.FileMode(rawMode&0777) | specialModeBits(uint32(rawMode)), nil
+}
+
+// resolveOwnership - Look up the numeric ids of remote_owner and remote_group on the host, names can differ between hosts
+func (remote *remoteHost) resolveOwnership(hostData hostObject) (int, int, error) {
+	uid, gid := -1, -1
+	var err error
+
+	if hostData.RemoteOwner != "" {
+		if uid, err = remote.lookupID(hostData.RemoteOwner, "id -u -- "+shellQuote(hostData.RemoteOwner), 0); err != nil {
+			return -1, -1, fmt.Errorf("unable to resolve remote_owner %s: %w", hostData.RemoteOwner, err)
+		}
+	}
+	if hostData.RemoteGroup != "" {
+		if gid, err = remote.lookupID(hostData.RemoteGroup, "getent group -- "+shellQuote(hostData.RemoteGroup), 2); err != nil {
+			return -1, -1, fmt.Errorf("unable to resolve remote_group %s: %w", hostData.RemoteGroup, err)
+		}
+	}
+
+	return uid, gid, nil
+}
+
+// lookupID - Numeric id of a user or group, names are resolved with a command whose output holds the id in
+// the given colon separated field
+func (remote *remoteHost) lookupID(name string, command string, field int) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+
+	output, err := remote.runCommand(command)
+	if err != nil {
+		return -1, err
+	}
+
+	fields := strings.Split(strings.TrimSpace(output), ":")
+	if len(fields) <= field {
+		return -1, fmt.Errorf("unexpected output %q", output)
+	}
+
+	return strconv.Atoi(fields[field])
+}
+
+// applyOwnership - Give a written file or created directory the configured owner, group and mode
+func (remote *remoteHost) applyOwnership(hostData hostObject, remotePath string, isDir bool) error {
+	if remote.uid >= 0 || remote.gid >= 0 {
+		if err := remote.FS.Chown(remotePath, remote.uid, remote.gid); err != nil {
+			return err
+		}
+	}
+
+	mode, configured := hostData.fileMode, hostData.FileMode != ""
+	if isDir {
+		mode, configured = hostData.dirMode, hostData.DirMode != ""
+	}
+	if configured {
+		return remote.FS.Chmod(remotePath, mode)
+	}
+
+	return nil
+}
+
+// expectedRemoteMode - Permissions the remote copy of a local entry should have, when they are controlled at all
+func (singleHost hostObject) expectedRemoteMode(entry treeEntry) (os.FileMode, bool) {
+	switch {
+	case entry.IsDir && singleHost.DirMode != "":
+		return singleHost.dirMode.Perm(), true
+	case !entry.IsDir && singleHost.FileMode != "":
+		return singleHost.fileMode.Perm(), true
+	}
+
+	return entry.Mode.Perm(), singleHost.PreserveMode
+}
+
+// createRemoteDirs - Create a remote directory along with its missing parents, each one created is given
+// the configured ownership
+func (remote *remoteHost) createRemoteDirs(hostData hostObject, remotePath string) error {
+	if remote.uid < 0 && remote.gid < 0 && hostData.DirMode == "" {
+		return remote.FS.MkdirAll(remotePath)
+	}
+
+	var missing []string
+	for dir := remotePath; ; dir = path.Dir(dir) {
+		if _, err := remote.FS.Stat(dir); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		missing = append(missing, dir)
+		if dir == path.Dir(dir) {
+			break
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if err := remote.FS.MkdirAll(remotePath); err != nil {
+		return err
+	}
+	for index := len(missing) - 1; index >= 0; index-- {
+		if err := remote.applyOwnership(hostData, missing[index], true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
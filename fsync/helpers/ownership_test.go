@@ -0,0 +1,35 @@
+package helpers
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		value string
+		want  os.FileMode
+	}{
+		{"", 0},
+		{"0644", 0644},
+		{"644", 0644},
+		{"0750", 0750},
+		{"2775", os.ModeSetgid | 0775},
+		{"4755", os.ModeSetuid | 0755},
+		{"1777", os.ModeSticky | 0777},
+		{"07777", os.ModeSetuid | os.ModeSetgid | os.ModeSticky | 0777},
+	}
+
+	for _, test := range tests {
+		got, err := parseMode(test.value)
+		if err != nil || got != test.want {
+			t.Errorf("parseMode(%q) = %s, %v, want %s", test.value, got, err, test.want)
+		}
+	}
+
+	for _, value := range []string{"0888", "rwxr-xr-x", "10000", "-644", "0x1ff"} {
+		if _, err := parseMode(value); err == nil {
+			t.Errorf("parseMode(%q) didn't fail", value)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package helpers
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// preflightResult - Outcome of a single pre-flight check of a host, Status is ok, warn, fail or skipped
+type preflightResult struct {
+	Check  string
+	Status string
+	Detail string
+}
+
+// shellCommands - Commands the shell and scp protocols run on the host
+var shellCommands = []string{"cat", "chmod", "chown", "df", "find", "mkdir", "mv", "pwd", "rm", "rmdir", "stat", "touch", "truncate"}
+
+// VerifyHosts - Run the pre-flight checks of every host, print them as a table and exit listing the hosts which failed
+func (hosts HostConfig) VerifyHosts() {
+	var petNames []string
+	for petName := range hosts.HostsMap {
+		petNames = append(petNames, petName)
+	}
+	sort.Strings(petNames)
+
+	results := make(map[string][]preflightResult)
+	var failed []string
+	for _, petName := range petNames {
+		fmt.Printf("[%s] Starting verification\n", petName)
+		results[petName] = hosts.preflightHost(hosts.HostsMap[petName])
+		for _, result := range results[petName] {
+			if result.Status == "fail" {
+				failed = append(failed, petName)
+				break
+			}
+		}
+	}
+
+	fmt.Printf("%-16s %-12s %-8s %s\n", "HOST", "CHECK", "RESULT", "DETAIL")
+	for _, petName := range petNames {
+		for _, result := range results[petName] {
+			fmt.Printf("%-16s %-12s %-8s %s\n", petName, result.Check, result.Status, result.Detail)
+		}
+	}
+
+	if len(failed) > 0 {
+		fmt.Printf("Pre-flight checks failed for %s\n", strings.Join(failed, ", "))
+		os.Exit(1)
+	}
+}
+
+// preflightHost - Check that a host is reachable and remote_dir can be synced into. Checks which depend
+// on a failed one are skipped.
+func (hosts HostConfig) preflightHost(hostData hostObject) []preflightResult {
+	skipped := func(results []preflightResult, checks ...string) []preflightResult {
+		for _, check := range checks {
+			results = append(results, preflightResult{check, "skipped", ""})
+		}
+		return results
+	}
+
+	remote, err := hosts.connectHost(&hostData)
+	if err != nil {
+		return skipped([]preflightResult{{"connect", "fail", err.Error()}}, "remote_dir", "writable", "free_space", "helpers")
+	}
+	defer remote.Close()
+
+	transport := "sftp"
+	if shell, ok := remote.FS.(shellFS); ok {
+		transport = "shell"
+		if shell.scp {
+			transport = "scp"
+		}
+	}
+	results := []preflightResult{{"connect", "ok", fmt.Sprintf("%s@%s:%d over %s", hostData.User, hostData.Hostname, hostData.Port, transport)}}
+
+	remoteDir := remote.checkRemoteDir(hostData)
+	results = append(results, remoteDir)
+	if remoteDir.Status == "fail" {
+		return skipped(results, "writable", "free_space", "helpers")
+	}
+
+	return append(results, remote.checkWritable(hostData), remote.checkFreeSpace(hostData), remote.checkHelpers(hostData))
+}
+
+// checkRemoteDir - remote_dir needs to be a directory, it is created when create_remote_dir is set
+func (remote *remoteHost) checkRemoteDir(hostData hostObject) preflightResult {
+	info, err := remote.FS.Stat(hostData.RemoteDir)
+	switch {
+	case err == nil && info.IsDir():
+		return preflightResult{"remote_dir", "ok", hostData.RemoteDir}
+	case err == nil:
+		return preflightResult{"remote_dir", "fail", hostData.RemoteDir + " isn't a directory"}
+	case !os.IsNotExist(err):
+		return preflightResult{"remote_dir", "fail", err.Error()}
+	case !hostData.CreateRemoteDir:
+		return preflightResult{"remote_dir", "fail", hostData.RemoteDir + " doesn't exist, set create_remote_dir to create it"}
+	}
+
+	if err = remote.createRemoteDirs(hostData, hostData.RemoteDir); err != nil {
+		return preflightResult{"remote_dir", "fail", fmt.Sprintf("unable to create %s: %s", hostData.RemoteDir, err)}
+	}
+	return preflightResult{"remote_dir", "ok", "created " + hostData.RemoteDir}
+}
+
+// checkWritable - Write and delete a probe file in remote_dir
+func (remote *remoteHost) checkWritable(hostData hostObject) preflightResult {
+	probePath := path.Join(hostData.RemoteDir, fmt.Sprintf(".fsync-probe-%d", os.Getpid()))
+	content := []byte("fsync pre-flight check\n")
+
+	writer, _, err := remote.FS.OpenWriter(probePath, 0, int64(len(content)), 0644)
+	if err == nil {
+		_, err = writer.Write(content)
+		if closeErr := writer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		remote.FS.Remove(probePath)
+		return preflightResult{"writable", "fail", fmt.Sprintf("unable to write %s: %s", probePath, err)}
+	}
+
+	if err = remote.FS.Remove(probePath); err != nil {
+		return preflightResult{"writable", "fail", fmt.Sprintf("unable to delete %s: %s", probePath, err)}
+	}
+	return preflightResult{"writable", "ok", ""}
+}
+
+// checkFreeSpace - Report the space left in remote_dir, failing below min_free_space. Servers without the
+// statvfs extension are asked with df instead.
+func (remote *remoteHost) checkFreeSpace(hostData hostObject) preflightResult {
+	free, err := remote.FS.FreeSpace(hostData.RemoteDir)
+	if _, isShell := remote.FS.(shellFS); err != nil && !isShell {
+		free, err = shellFS{ssh: remote.SSH}.FreeSpace(hostData.RemoteDir)
+	}
+	if err != nil {
+		if hostData.minFreeSpace > 0 {
+			return preflightResult{"free_space", "fail", "unable to read the free space: " + err.Error()}
+		}
+		return preflightResult{"free_space", "warn", "unable to read the free space: " + err.Error()}
+	}
+
+	detail := formatBytes(int64(free)) + " free"
+	if hostData.minFreeSpace > 0 && free < uint64(hostData.minFreeSpace) {
+		return preflightResult{"free_space", "fail", fmt.Sprintf("%s, min_free_space is %s", detail, formatBytes(hostData.minFreeSpace))}
+	}
+	return preflightResult{"free_space", "ok", detail}
+}
+
+// checkHelpers - Look for the commands fsync runs on the host. The shell transports can't work without theirs,
+// sha256sum and tar only speed things up as their work is otherwise done over the transport.
+func (remote *remoteHost) checkHelpers(hostData hostObject) preflightResult {
+	var required, optional []string
+	if shell, ok := remote.FS.(shellFS); ok {
+		required = append(required, shellCommands...)
+		if shell.scp {
+			required = append(required, "scp")
+		}
+	}
+	if hostData.Verify == VerifyHash || hostData.Verify == "" {
+		optional = append(optional, "sha256sum", "xargs")
+	}
+	if hostData.Compress || hostData.TarThreshold >= 0 {
+		optional = append(optional, "tar", "xargs")
+	}
+	if len(required)+len(optional) == 0 {
+		return preflightResult{"helpers", "ok", "none needed"}
+	}
+
+	var quoted []string
+	for _, command := range append(required, optional...) {
+		quoted = append(quoted, shellQuote(command))
+	}
+	output, err := remote.runCommand(`for command in ` + strings.Join(quoted, " ") + `; do command -v "$command" >/dev/null 2>&1 || echo "$command"; done`)
+	if err != nil {
+		return preflightResult{"helpers", "warn", "unable to look for the commands: " + err.Error()}
+	}
+
+	missing := make(map[string]bool)
+	for _, command := range strings.Fields(output) {
+		missing[command] = true
+	}
+	var missingRequired, missingOptional []string
+	for _, command := range required {
+		if missing[command] {
+			missingRequired = append(missingRequired, command)
+		}
+	}
+	for _, command := range optional {
+		if missing[command] && !slices.Contains(missingOptional, command) {
+			missingOptional = append(missingOptional, command)
+		}
+	}
+
+	switch {
+	case len(missingRequired) > 0:
+		return preflightResult{"helpers", "fail", fmt.Sprintf("%s missing, needed without SFTP", strings.Join(missingRequired, ", "))}
+	case len(missingOptional) > 0:
+		return preflightResult{"helpers", "warn", fmt.Sprintf("%s missing, falling back to slower transfers", strings.Join(missingOptional, ", "))}
+	}
+	return preflightResult{"helpers", "ok", "all present"}
+}
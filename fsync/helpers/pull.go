@@ -0,0 +1,195 @@
+package helpers
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const downloadSuffix = ".fsync-tmp"
+
+// PullHost - Mirror RemoteDir of a single host into its LocalDir
+func (hosts HostConfig) PullHost(petName string, deleteExtra bool, dryRun bool) {
+	hostData := hosts.lookupHost(petName)
+
+	remote, err := hosts.connectHost(&hostData)
+	if err != nil {
+		fmt.Printf("[%s] Encountered error while connecting: %s\n", petName, err)
+		os.Exit(1)
+	}
+	defer remote.Close()
+
+	// A missing remote_dir would look like an empty remote and --delete would wipe LocalDir
+	info, err := remote.FS.Stat(hostData.RemoteDir)
+	if err == nil && !info.IsDir() {
+		err = fmt.Errorf("%s isn't a directory", hostData.RemoteDir)
+	}
+	if err != nil {
+		fmt.Printf("[%s] Unable to pull %s: %s\n", petName, hostData.RemoteDir, err)
+		os.Exit(1)
+	}
+
+	remoteTree, err := hostData.listRemoteTree(remote.FS)
+	if err != nil {
+		fmt.Printf("[%s] Encountered error while listing %s: %s\n", petName, hostData.RemoteDir, err)
+		os.Exit(1)
+	}
+
+	localTree, err := hostData.listLocalTree()
+	if err != nil {
+		fmt.Printf("[%s] Encountered error while listing %s: %s\n", petName, hostData.LocalDir, err)
+		os.Exit(1)
+	}
+
+	var downloads, removals []string
+	for relPath, remoteEntry := range remoteTree {
+		localEntry, exists := localTree[relPath]
+		if remoteEntry.IsDir {
+			if !exists || !localEntry.IsDir {
+				downloads = append(downloads, relPath)
+			}
+			continue
+		}
+		if !exists || localEntry.IsDir || hostData.needsTransfer(remoteEntry, localEntry) {
+			downloads = append(downloads, relPath)
+		}
+	}
+	if deleteExtra {
+		for relPath, localEntry := range localTree {
+			if remoteEntry, exists := remoteTree[relPath]; !exists || remoteEntry.IsDir != localEntry.IsDir {
+				removals = append(removals, relPath)
+			}
+		}
+	}
+
+	// Parents are created before their children and removed after them
+	sort.Strings(downloads)
+	sort.Sort(sort.Reverse(sort.StringSlice(removals)))
+
+	if dryRun {
+		for _, relPath := range removals {
+			fmt.Printf("[%s] Would delete %s\n", petName, hostData.localPath(relPath))
+		}
+		for _, relPath := range downloads {
+			if remoteTree[relPath].IsDir {
+				fmt.Printf("[%s] Would create directory %s\n", petName, hostData.localPath(relPath))
+			} else {
+				fmt.Printf("[%s] Would download %s (%d bytes)\n", petName, hostData.remotePath(relPath), remoteTree[relPath].Size)
+			}
+		}
+		fmt.Printf("[%s] Dry run: %d to download, %d to delete\n", petName, len(downloads), len(removals))
+		return
+	}
+
+	err = os.MkdirAll(hostData.LocalDir, 0755)
+	if err != nil {
+		fmt.Printf("[%s] Unable to create %s: %s\n", petName, hostData.LocalDir, err)
+		os.Exit(1)
+	}
+
+	failed := 0
+	for _, relPath := range removals {
+		fmt.Printf("[%s] Deleting %s\n", petName, hostData.localPath(relPath))
+		if err = os.RemoveAll(hostData.localPath(relPath)); err != nil {
+			fmt.Printf("[%s] Unable to delete %s: %s\n", petName, hostData.localPath(relPath), err)
+			failed++
+		}
+	}
+	for _, relPath := range downloads {
+		if err = hostData.downloadEntry(remote, remoteTree[relPath]); err != nil {
+			fmt.Printf("[%s] Unable to download %s: %s\n", petName, hostData.remotePath(relPath), err)
+			failed++
+		}
+	}
+
+	fmt.Printf("[%s] Pull finished: %d downloaded, %d deleted, %d failed\n", petName, len(downloads), len(removals), failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// needsTransfer - Compare a source entry with its destination counterpart
+func (singleHost hostObject) needsTransfer(source treeEntry, destination treeEntry) bool {
+	if source.Size != destination.Size {
+		return true
+	}
+	if singleHost.PreserveTimes {
+		return source.ModTime.Unix() != destination.ModTime.Unix()
+	}
+
+	// SFTP only carries whole seconds
+	return source.ModTime.Unix() > destination.ModTime.Unix()
+}
+
+// downloadEntry - Copy a remote file or directory into LocalDir, applying the metadata options
+func (singleHost hostObject) downloadEntry(remote *remoteHost, entry treeEntry) error {
+	localPath := singleHost.localPath(entry.RelPath)
+
+	if entry.IsDir {
+		if info, err := os.Stat(localPath); err == nil && !info.IsDir() {
+			if err = os.Remove(localPath); err != nil {
+				return err
+			}
+		}
+		if err := os.MkdirAll(localPath, 0755); err != nil {
+			return err
+		}
+		return singleHost.applyLocalMetadata(localPath, entry)
+	}
+
+	if info, err := os.Stat(localPath); err == nil && info.IsDir() {
+		if err = os.RemoveAll(localPath); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+
+	remoteFile, err := remote.FS.Open(singleHost.remotePath(entry.RelPath))
+	if err != nil {
+		return err
+	}
+	defer remoteFile.Close()
+
+	tmpPath := filepath.Join(filepath.Dir(localPath), fmt.Sprintf(".%s%s", filepath.Base(localPath), downloadSuffix))
+	localFile, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(localFile, remoteFile)
+	if closeErr := localFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err = singleHost.applyLocalMetadata(tmpPath, entry); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, localPath)
+}
+
+// applyLocalMetadata - Copy the mode and modification time of a remote entry when enabled. Files always get
+// the remote modification time, otherwise the next run would find every pulled file newer and upload it again.
+func (singleHost hostObject) applyLocalMetadata(localPath string, entry treeEntry) error {
+	if singleHost.PreserveMode {
+		if err := os.Chmod(localPath, entry.Mode.Perm()); err != nil {
+			return err
+		}
+	}
+	if singleHost.PreserveTimes || !entry.IsDir {
+		if err := os.Chtimes(localPath, entry.ModTime, entry.ModTime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,176 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Exit codes of the push action
+const (
+	PushOK       = 0
+	PushFailed   = 1
+	PushNoTarget = 2
+)
+
+// pushResult - Outcome of a single file for a single host
+type pushResult struct {
+	Path       string `json:"path"`
+	Host       string `json:"host,omitempty"`
+	RemotePath string `json:"remote_path,omitempty"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
+
+// pushReport - JSON document printed by the push action
+type pushReport struct {
+	OK      bool         `json:"ok"`
+	Results []pushResult `json:"results"`
+}
+
+// PushPaths - Upload the given files to every host whose LocalDir contains them, print a JSON report and return the exit code
+func (hosts HostConfig) PushPaths(paths []string) int {
+	var report pushReport
+
+	// Progress messages go to stderr so stdout only carries the JSON result
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	defer func() { os.Stdout = stdout }()
+
+	hostFiles := make(map[string][]string)
+	for _, inputPath := range paths {
+		absPath, err := filepath.Abs(inputPath)
+		if err != nil {
+			report.Results = append(report.Results, pushResult{Path: inputPath, Status: "failed", Error: err.Error()})
+			continue
+		}
+
+		matched := false
+		for petName, hostData := range hosts.HostsMap {
+			relPath, err := hostData.relativePath(absPath)
+			if err != nil || relPath == ".." || strings.HasPrefix(relPath, "../") {
+				continue
+			}
+			matched = true
+
+			files, err := hostData.collectFiles(relPath)
+			if err != nil {
+				report.Results = append(report.Results, pushResult{Path: absPath, Host: petName, Status: "failed", Error: err.Error()})
+				continue
+			}
+			if len(files) == 0 {
+				report.Results = append(report.Results, pushResult{Path: absPath, Host: petName, Status: "ignored"})
+				continue
+			}
+			hostFiles[petName] = append(hostFiles[petName], files...)
+		}
+
+		if !matched {
+			report.Results = append(report.Results, pushResult{Path: absPath, Status: "no_host", Error: "path isn't inside the local_dir of any host"})
+		}
+	}
+
+	var petNames []string
+	for petName := range hostFiles {
+		petNames = append(petNames, petName)
+	}
+	sort.Strings(petNames)
+
+	for _, petName := range petNames {
+		hostData := hosts.HostsMap[petName]
+		session, connectErr := hosts.newSession(petName)
+		for _, relPath := range hostFiles[petName] {
+			result := pushResult{Path: hostData.localPath(relPath), Host: petName, RemotePath: hostData.remotePath(relPath), Status: "uploaded"}
+			if info, err := os.Stat(result.Path); err == nil {
+				if err = hostData.checkFileSize(info.Size()); err != nil {
+					result.Status = "skipped"
+					result.Error = err.Error()
+					report.Results = append(report.Results, result)
+					continue
+				}
+			}
+
+			err := connectErr
+			if err == nil {
+				err = session.uploadFile(relPath)
+			}
+			if err != nil {
+				result.Status = "failed"
+				result.Error = err.Error()
+			}
+			report.Results = append(report.Results, result)
+		}
+		if connectErr == nil {
+			session.Remote.Close()
+		}
+	}
+
+	exitCode := PushOK
+	for _, result := range report.Results {
+		switch result.Status {
+		case "failed":
+			exitCode = PushFailed
+		case "no_host":
+			if exitCode == PushOK {
+				exitCode = PushNoTarget
+			}
+		}
+	}
+	report.OK = exitCode == PushOK
+
+	output, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Fprintln(stdout, string(output))
+
+	return exitCode
+}
+
+// collectFiles - Regular files at or below a path relative to LocalDir which aren't ignored, by git as well in git mode
+func (singleHost hostObject) collectFiles(relPath string) ([]string, error) {
+	var files []string
+
+	if relPath != "." && singleHost.isIgnored(relPath) {
+		return nil, nil
+	}
+
+	err := filepath.WalkDir(singleHost.localPath(relPath), func(walkPath string, dirEntry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		walkRel, err := singleHost.relativePath(walkPath)
+		if err != nil {
+			return err
+		}
+		if walkRel != "." && singleHost.isIgnored(walkRel) {
+			if dirEntry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if dirEntry.Type().IsRegular() {
+			files = append(files, walkRel)
+		}
+
+		return nil
+	})
+	if err != nil || !singleHost.GitMode {
+		return files, err
+	}
+
+	ignored, err := singleHost.gitIgnored(files)
+	if err != nil {
+		return nil, err
+	}
+	var shipped []string
+	for _, file := range files {
+		if !ignored[file] {
+			shipped = append(shipped, file)
+		}
+	}
+
+	return shipped, nil
+}
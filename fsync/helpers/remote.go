@@ -0,0 +1,206 @@
+package helpers
+
+import (
+	"fmt"
+	"github.com/pkg/sftp"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/crypto/ssh"
+	"os"
+	"strings"
+	"time"
+)
+
+const connectTimeout = 15 * time.Second
+const keepaliveTimeout = 10 * time.Second
+
+// remoteHost - Open SSH connection to a single host and the file transport running on top of it
+type remoteHost struct {
+	SSH *ssh.Client
+	FS  remoteFS
+
+	// Owner and group written files are given, -1 when not configured
+	uid int
+	gid int
+}
+
+// connectHost - Dial the host over SSH and start the transport selected by protocol on top of the connection.
+// In auto mode hosts with the SFTP subsystem disabled fall back to shell commands.
+func (hosts HostConfig) connectHost(hostData *hostObject) (*remoteHost, error) {
+	address := fmt.Sprintf("%s:%d", hostData.Hostname, hostData.Port)
+	sshConfig := &ssh.ClientConfig{
+		User: hostData.User,
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeys(hosts.SSHKey),
+		},
+		HostKeyCallback: hosts.Hosts,
+		Timeout:         connectTimeout,
+	}
+	// Only offer the algorithms of the known keys, otherwise a server preferring another type looks like a changed key
+	if hosts.HostKeyAlgorithms != nil {
+		sshConfig.HostKeyAlgorithms = hosts.HostKeyAlgorithms(address)
+	}
+
+	conn, err := ssh.Dial("tcp", address, sshConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect over ssh: %w", err)
+	}
+
+	remote := &remoteHost{SSH: conn}
+	switch hostData.Protocol {
+	case ProtocolSCP, ProtocolShell:
+		remote.FS = shellFS{ssh: conn, scp: hostData.Protocol == ProtocolSCP}
+	default:
+		client, err := sftp.NewClient(conn)
+		if err != nil {
+			if hostData.Protocol == ProtocolSFTP {
+				conn.Close()
+				return nil, fmt.Errorf("unable to create sftp client: %w", err)
+			}
+			fmt.Printf("SFTP isn't available on %s, falling back to shell commands: %s\n", hostData.Hostname, err)
+			remote.FS = shellFS{ssh: conn}
+		} else {
+			remote.FS = sftpFS{client}
+		}
+	}
+
+	// A leading ~ in remote_dir refers to the remote home, which is only known now
+	if hostData.RemoteDir, err = expandRemoteHome(remote.FS, hostData.RemoteDir); err != nil {
+		remote.Close()
+		return nil, err
+	}
+
+	if remote.uid, remote.gid, err = remote.resolveOwnership(*hostData); err != nil {
+		remote.Close()
+		return nil, err
+	}
+
+	return remote, nil
+}
+
+// Close - Close the transport and the underlying SSH connection
+func (remote *remoteHost) Close() {
+	remote.FS.Close()
+	remote.SSH.Close()
+}
+
+// lookupHost - Find a host by pet name or exit when it isn't configured
+func (hosts HostConfig) lookupHost(petName string) hostObject {
+	if petName == "" {
+		fmt.Println("No host selected, please provide the pet name of a configured host")
+		os.Exit(1)
+	}
+
+	hostData, ok := hosts.HostsMap[petName]
+	if !ok {
+		fmt.Printf("Host %s isn't present in the config\n", petName)
+		os.Exit(1)
+	}
+
+	return hostData
+}
+
+// runCommand - Run a command over the SSH connection and return its standard output
+func (remote *remoteHost) runCommand(command string) (string, error) {
+	session, err := remote.SSH.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	var stderr strings.Builder
+	session.Stderr = &stderr
+	output, err := session.Output(command)
+	if err != nil {
+		return string(output), fmt.Errorf("%s: %w %s", command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return string(output), nil
+}
+
+// shellQuote - Quote a value for use in a POSIX shell command
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// syncSession - Long lived connection to a host which is re-established when it drops
+type syncSession struct {
+	PetName string
+	Host    hostObject
+	Config  HostConfig
+	Remote  *remoteHost
+	queue   chan eventBatch
+
+	initialDone bool
+	gitHead     string
+	unreachable bool
+}
+
+// newSession - Connect to a configured host
+func (hosts HostConfig) newSession(petName string) (*syncSession, error) {
+	session := &syncSession{PetName: petName, Host: hosts.HostsMap[petName], Config: hosts}
+	if err := session.reconnect(); err != nil {
+		return nil, err
+	}
+
+	// Export the series of every host from the start so a stalled host still shows up
+	for _, metric := range []*prometheus.CounterVec{eventsSeen, filesUploaded, bytesUploaded, reconnects} {
+		metric.WithLabelValues(petName)
+	}
+	queueDepth.WithLabelValues(petName)
+	lastSuccessfulSync.WithLabelValues(petName)
+
+	return session, nil
+}
+
+// reconnect - Drop the current connection, if any, and dial the host again
+func (session *syncSession) reconnect() error {
+	state := "connecting"
+	if session.Remote != nil {
+		session.Remote.Close()
+		session.Remote = nil
+		reconnects.WithLabelValues(session.PetName).Inc()
+		state = "reconnecting"
+	}
+	board.update(session.PetName, func(status *hostStatus) { status.State = state })
+
+	remote, err := session.Config.connectHost(&session.Host)
+	if err != nil {
+		board.update(session.PetName, func(status *hostStatus) {
+			status.State = "unreachable"
+			if status.UnreachableSince.IsZero() {
+				status.UnreachableSince = time.Now()
+			}
+		})
+		return err
+	}
+	session.Remote = remote
+	board.update(session.PetName, func(status *hostStatus) {
+		status.State = "connected"
+		status.UnreachableSince = time.Time{}
+	})
+
+	return nil
+}
+
+// alive - Check whether the SSH connection still responds
+func (session *syncSession) alive() bool {
+	if session.Remote == nil {
+		return false
+	}
+
+	// A connection whose peer vanished without a reset never answers, the request is abandoned after keepaliveTimeout
+	// and unblocks once the connection is closed by the reconnect which follows
+	client := session.Remote.SSH
+	reply := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		reply <- err
+	}()
+
+	select {
+	case err := <-reply:
+		return err == nil
+	case <-time.After(keepaliveTimeout):
+		return false
+	}
+}
@@ -0,0 +1,264 @@
+package helpers
+
+import (
+	"fmt"
+	"github.com/radovskyb/watcher"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// resumeUploads - Finish the uploads which were interrupted in a previous run
+func (session *syncSession) resumeUploads() {
+	for _, partial := range session.Config.Uploads.pending(session.PetName) {
+		relPath, err := session.Host.relativePath(partial.LocalPath)
+		if err != nil || session.Host.remotePath(relPath) != partial.RemotePath {
+			session.Config.Uploads.remove(session.PetName, partial.RemotePath)
+			continue
+		}
+		if _, err = os.Stat(partial.LocalPath); err != nil {
+			fmt.Printf("[%s] Dropping partial upload of %s: %s\n", session.PetName, relPath, err)
+			session.Config.Uploads.remove(session.PetName, partial.RemotePath)
+			if session.Remote != nil {
+				session.Remote.FS.Remove(partial.TempPath)
+			}
+			continue
+		}
+
+		if err = session.uploadFile(relPath); err != nil {
+			fmt.Printf("[%s] Unable to resume upload of %s: %s\n", session.PetName, relPath, err)
+		}
+	}
+}
+
+// reconcile - Upload every local file which is missing or outdated on the remote
+func (session *syncSession) reconcile() error {
+	localTree, err := session.Host.listLocalTree()
+	if err != nil {
+		return err
+	}
+	var remoteTree map[string]treeEntry
+	err = session.withRetry("list "+session.Host.RemoteDir, func() error {
+		var listErr error
+		remoteTree, listErr = session.Host.listRemoteTree(session.Remote.FS)
+		return listErr
+	})
+	if err != nil {
+		return err
+	}
+
+	var relPaths []string
+	for relPath := range localTree {
+		relPaths = append(relPaths, relPath)
+	}
+	sort.Strings(relPaths)
+
+	uploaded, failed := 0, 0
+	var pending []string
+	sizes := make(map[string]int64)
+	for _, relPath := range relPaths {
+		localEntry := localTree[relPath]
+		remoteEntry, exists := remoteTree[relPath]
+		if localEntry.IsDir {
+			if !exists && session.makeRemoteDir(relPath) != nil {
+				failed++
+			}
+			continue
+		}
+		if exists && !remoteEntry.IsDir && !session.Host.needsTransfer(localEntry, remoteEntry) {
+			continue
+		}
+		if session.tooLarge(relPath, localEntry.Size) {
+			continue
+		}
+		pending = append(pending, relPath)
+		sizes[relPath] = localEntry.Size
+	}
+
+	if session.Host.useTarBatches(len(pending)) {
+		batches, single := session.splitBatches(pending, sizes)
+		pending = single
+		for _, batch := range batches {
+			batchUploaded, remaining := session.uploadBatch(batch)
+			uploaded += batchUploaded
+			pending = append(pending, remaining...)
+		}
+	}
+
+	for _, relPath := range pending {
+		if err = session.uploadFile(relPath); err != nil {
+			fmt.Printf("[%s] Unable to upload %s: %s\n", session.PetName, relPath, err)
+			session.Config.Failed.add(session.PetName, watcher.Event{Op: watcher.Write, Path: session.Host.localPath(relPath)}, err)
+			failed++
+			continue
+		}
+		session.Config.Failed.resolve(session.PetName, session.Host.localPath(relPath))
+		uploaded++
+	}
+
+	fmt.Printf("[%s] Initial sync finished, %d files uploaded\n", session.PetName, uploaded)
+	if failed > 0 {
+		return fmt.Errorf("%d files couldn't be synced", failed)
+	}
+	return nil
+}
+
+// handleEvent - Apply a single watcher event to the remote, failures are reported and the last one returned
+func (session *syncSession) handleEvent(event watcher.Event) error {
+	if isRenameEvent(event) {
+		return session.renameEvent(event)
+	}
+
+	relPath, err := session.Host.relativePath(event.Path)
+	if err != nil || relPath == "." || session.Host.isIgnored(relPath) {
+		return nil
+	}
+
+	switch event.Op {
+	case watcher.Create, watcher.Write, watcher.Chmod:
+		return session.uploadPath(relPath, false)
+	case watcher.Remove:
+		return session.removeEventPath(relPath)
+	}
+
+	return nil
+}
+
+// renameEvent - Rename on the remote as well, uploading the new path if the old one never made it there
+func (session *syncSession) renameEvent(event watcher.Event) error {
+	relPath, err := session.Host.relativePath(event.Path)
+	newSynced := err == nil && relPath != "." && !session.Host.isIgnored(relPath)
+	oldRelPath, err := session.Host.relativePath(event.OldPath)
+	oldSynced := err == nil && oldRelPath != "." && !session.Host.isIgnored(oldRelPath)
+
+	switch {
+	case !newSynced && !oldSynced:
+		return nil
+	case !newSynced:
+		return session.removeEventPath(oldRelPath)
+	case !oldSynced:
+		return session.uploadPath(relPath, true)
+	}
+
+	start := time.Now()
+	oldRemotePath, newRemotePath := session.Host.remotePath(oldRelPath), session.Host.remotePath(relPath)
+	sourceMissing := false
+
+	err = session.withRetry("rename "+oldRelPath, func() error {
+		client := session.Remote.FS
+		if _, err := client.Lstat(oldRemotePath); os.IsNotExist(err) {
+			sourceMissing = true
+			return nil
+		}
+
+		if err := session.Remote.createRemoteDirs(session.Host, path.Dir(newRemotePath)); err != nil {
+			return err
+		}
+		return replaceRemote(client, oldRemotePath, newRemotePath)
+	})
+	if sourceMissing {
+		fmt.Printf("[%s] %s is missing on the remote, uploading %s instead\n", session.PetName, oldRelPath, relPath)
+		return session.uploadPath(relPath, true)
+	}
+	session.record("rename", relPath, newRemotePath, 0, start, "", err)
+	if err != nil {
+		fmt.Printf("[%s] Unable to rename %s to %s: %s\n", session.PetName, oldRelPath, relPath, err)
+		return err
+	}
+
+	fmt.Printf("[%s] Renamed %s to %s\n", session.PetName, oldRelPath, relPath)
+	return nil
+}
+
+// uploadPath - Upload a file or create a directory, with recursive also uploading everything inside it
+func (session *syncSession) uploadPath(relPath string, recursive bool) error {
+	info, err := os.Stat(session.Host.localPath(relPath))
+	if err != nil {
+		// The path is already gone again, a remove event will follow
+		return nil
+	}
+
+	if info.Mode().IsRegular() {
+		if session.tooLarge(relPath, info.Size()) {
+			return nil
+		}
+		fmt.Printf("[%s] Uploading %s (%d bytes)\n", session.PetName, relPath, info.Size())
+		if err = session.uploadFile(relPath); err != nil {
+			fmt.Printf("[%s] Unable to upload %s: %s\n", session.PetName, relPath, err)
+		}
+		return err
+	}
+	if !info.IsDir() {
+		return nil
+	}
+
+	var result error
+	if result = session.makeRemoteDir(relPath); result != nil || !recursive {
+		return result
+	}
+
+	walkErr := filepath.WalkDir(session.Host.localPath(relPath), func(walkPath string, dirEntry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		childPath, err := session.Host.relativePath(walkPath)
+		if err != nil || childPath == relPath {
+			return err
+		}
+		if session.Host.isIgnored(childPath) {
+			if dirEntry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if err = session.uploadPath(childPath, false); err != nil {
+			result = err
+		}
+		return nil
+	})
+	if walkErr != nil {
+		fmt.Printf("[%s] Unable to read %s: %s\n", session.PetName, relPath, walkErr)
+		return walkErr
+	}
+
+	return result
+}
+
+// removeEventPath - Apply the delete policy to a removed path and report failures
+func (session *syncSession) removeEventPath(relPath string) error {
+	err := session.removeRemote(relPath)
+	if err != nil {
+		fmt.Printf("[%s] Unable to remove %s: %s\n", session.PetName, relPath, err)
+	}
+
+	return err
+}
+
+// makeRemoteDir - Create a directory relative to RemoteDir, including its parents
+func (session *syncSession) makeRemoteDir(relPath string) error {
+	start := time.Now()
+	remotePath := session.Host.remotePath(relPath)
+	created := false
+
+	err := session.withRetry("create directory "+relPath, func() error {
+		if info, err := session.Remote.FS.Stat(remotePath); err == nil && info.IsDir() {
+			return nil
+		}
+		created = true
+		return session.Remote.createRemoteDirs(session.Host, remotePath)
+	})
+	if !created {
+		return err
+	}
+	session.record("mkdir", relPath, remotePath, 0, start, "", err)
+	if err != nil {
+		fmt.Printf("[%s] Unable to create directory %s: %s\n", session.PetName, relPath, err)
+	}
+
+	return err
+}
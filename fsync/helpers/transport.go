@@ -0,0 +1,542 @@
+package helpers
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/kr/fs"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported values of protocol
+const (
+	ProtocolAuto  = "auto"
+	ProtocolSFTP  = "sftp"
+	ProtocolSCP   = "scp"
+	ProtocolShell = "shell"
+)
+
+// remoteFS - File operations on a host, over SFTP or emulated with shell commands when the subsystem is disabled
+type remoteFS interface {
+	Stat(remotePath string) (os.FileInfo, error)
+	Lstat(remotePath string) (os.FileInfo, error)
+	Walk(root string) *fs.Walker
+	MkdirAll(remotePath string) error
+	Remove(remotePath string) error
+	RemoveAll(remotePath string) error
+	Rename(oldPath string, newPath string) error
+	PosixRename(oldPath string, newPath string) error
+	Chmod(remotePath string, mode os.FileMode) error
+	// Chown - Change the owner and group of a file, -1 keeps the current one
+	Chown(remotePath string, uid int, gid int) error
+	Chtimes(remotePath string, atime time.Time, mtime time.Time) error
+	Getwd() (string, error)
+	// FreeSpace - Bytes available to the login user on the file system holding remotePath
+	FreeSpace(remotePath string) (uint64, error)
+	Open(remotePath string) (io.ReadCloser, error)
+	// OpenWriter - Continue writing a file of the given final size at offset, returning the offset it actually continues at.
+	// Transports which have to announce the permissions of a new file use mode.
+	OpenWriter(remotePath string, offset int64, size int64, mode os.FileMode) (io.WriteCloser, int64, error)
+	Close() error
+}
+
+// sftpFS - remoteFS backed by the SFTP subsystem
+type sftpFS struct {
+	*sftp.Client
+}
+
+// Open - Open a remote file for reading
+func (client sftpFS) Open(remotePath string) (io.ReadCloser, error) {
+	file, err := client.Client.Open(remotePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// OpenWriter - Open a remote file for writing at offset, anything after it is discarded
+func (client sftpFS) OpenWriter(remotePath string, offset int64, size int64, mode os.FileMode) (io.WriteCloser, int64, error) {
+	file, err := client.OpenFile(remotePath, os.O_WRONLY|os.O_CREATE)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err = file.Truncate(offset); err == nil {
+		_, err = file.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+
+	return file, offset, nil
+}
+
+// Chown - Change the owner and group of a file. SFTP always sets both, so the one kept is read first.
+func (client sftpFS) Chown(remotePath string, uid int, gid int) error {
+	if uid < 0 || gid < 0 {
+		info, err := client.Lstat(remotePath)
+		if err != nil {
+			return err
+		}
+		if stat, ok := info.Sys().(*sftp.FileStat); ok {
+			if uid < 0 {
+				uid = int(stat.UID)
+			}
+			if gid < 0 {
+				gid = int(stat.GID)
+			}
+		}
+	}
+
+	return client.Client.Chown(remotePath, uid, gid)
+}
+
+// FreeSpace - Bytes available to the login user, needs the statvfs@openssh.com extension
+func (client sftpFS) FreeSpace(remotePath string) (uint64, error) {
+	stat, err := client.StatVFS(remotePath)
+	if err != nil {
+		return 0, err
+	}
+
+	return stat.Bavail * stat.Frsize, nil
+}
+
+// shellFS - remoteFS emulated with GNU coreutils commands over plain SSH sessions.
+// Files are written with cat, which can resume at an offset, or with the SCP protocol when scp is set.
+type shellFS struct {
+	ssh *ssh.Client
+	scp bool
+}
+
+// shellFileInfo - os.FileInfo built from the output of stat
+type shellFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (info shellFileInfo) Name() string       { return info.name }
+func (info shellFileInfo) Size() int64        { return info.size }
+func (info shellFileInfo) Mode() os.FileMode  { return info.mode }
+func (info shellFileInfo) ModTime() time.Time { return info.modTime }
+func (info shellFileInfo) IsDir() bool        { return info.mode.IsDir() }
+func (info shellFileInfo) Sys() any           { return nil }
+
+// statFormat - Size, raw mode in hex, mtime and name of a file, NUL terminated
+const statFormat = `'%s %f %Y %n\0'`
+
+// shellError - Turn the stderr of a failed command into the matching os error where possible
+func shellError(operation string, remotePath string, message string, err error) error {
+	switch {
+	case strings.Contains(message, "No such file or directory"):
+		err = os.ErrNotExist
+	case strings.Contains(message, "Permission denied"), strings.Contains(message, "Operation not permitted"):
+		err = os.ErrPermission
+	case message != "":
+		err = errors.New(message)
+	}
+
+	return &os.PathError{Op: operation, Path: remotePath, Err: err}
+}
+
+// run - Run a command on the host and return its output
+func (shell shellFS) run(operation string, remotePath string, command string) (string, error) {
+	session, err := shell.ssh.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	var stderr strings.Builder
+	session.Stderr = &stderr
+	output, err := session.Output(command)
+	if err != nil {
+		return "", shellError(operation, remotePath, strings.TrimSpace(stderr.String()), err)
+	}
+
+	return string(output), nil
+}
+
+// parseStat - Parse the NUL terminated records printed with statFormat
+func parseStat(output string) ([]os.FileInfo, error) {
+	var infos []os.FileInfo
+	for _, record := range strings.Split(output, "\x00") {
+		if record == "" {
+			continue
+		}
+
+		fields := strings.SplitN(record, " ", 4)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("unexpected stat output %q", record)
+		}
+		size, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		rawMode, err := strconv.ParseUint(fields[1], 16, 32)
+		if err != nil {
+			return nil, err
+		}
+		modTime, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		infos = append(infos, shellFileInfo{path.Base(fields[3]), size, rawFileMode(uint32(rawMode)), time.Unix(modTime, 0)})
+	}
+
+	return infos, nil
+}
+
+// rawFileMode - Convert a st_mode value to an os.FileMode
+func rawFileMode(rawMode uint32) os.FileMode {
+	mode := os.FileMode(rawMode & 0777)
+	switch rawMode & 0170000 {
+	case 0040000:
+		mode |= os.ModeDir
+	case 0120000:
+		mode |= os.ModeSymlink
+	case 0100000:
+	default:
+		mode |= os.ModeIrregular
+	}
+	mode |= specialModeBits(rawMode)
+
+	return mode
+}
+
+// specialModeBits - Setuid, setgid and sticky bits of a st_mode value as os.FileMode bits
+func specialModeBits(rawMode uint32) os.FileMode {
+	var mode os.FileMode
+	if rawMode&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if rawMode&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if rawMode&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+
+	return mode
+}
+
+// octalMode - Permission and special bits of an os.FileMode in the octal notation of chmod
+func octalMode(mode os.FileMode) uint32 {
+	octal := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		octal |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		octal |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		octal |= 01000
+	}
+
+	return octal
+}
+
+// stat - Stat a single path, following symlinks when follow is set
+func (shell shellFS) stat(remotePath string, follow bool) (os.FileInfo, error) {
+	flags := ""
+	if follow {
+		flags = "-L "
+	}
+
+	output, err := shell.run("stat", remotePath, "stat "+flags+"--printf "+statFormat+" -- "+shellQuote(remotePath))
+	if err != nil {
+		return nil, err
+	}
+	infos, err := parseStat(output)
+	if err != nil {
+		return nil, err
+	}
+	if len(infos) != 1 {
+		return nil, fmt.Errorf("unexpected stat output for %s", remotePath)
+	}
+
+	return infos[0], nil
+}
+
+func (shell shellFS) Stat(remotePath string) (os.FileInfo, error) {
+	return shell.stat(remotePath, true)
+}
+
+func (shell shellFS) Lstat(remotePath string) (os.FileInfo, error) {
+	return shell.stat(remotePath, false)
+}
+
+// ReadDir - Entries of a remote directory, needed for walking it
+func (shell shellFS) ReadDir(remotePath string) ([]os.FileInfo, error) {
+	command := fmt.Sprintf("test -d %[1]s && find %[1]s -mindepth 1 -maxdepth 1 -exec stat --printf %[2]s -- {} +", shellQuote(remotePath), statFormat)
+	output, err := shell.run("readdir", remotePath, command)
+	if err != nil {
+		if _, statErr := shell.Lstat(remotePath); statErr != nil {
+			return nil, statErr
+		}
+		return nil, err
+	}
+
+	return parseStat(output)
+}
+
+func (shell shellFS) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+func (shell shellFS) Walk(root string) *fs.Walker {
+	return fs.WalkFS(root, shell)
+}
+
+func (shell shellFS) MkdirAll(remotePath string) error {
+	_, err := shell.run("mkdir", remotePath, "mkdir -p -- "+shellQuote(remotePath))
+	return err
+}
+
+func (shell shellFS) Remove(remotePath string) error {
+	quoted := shellQuote(remotePath)
+	_, err := shell.run("remove", remotePath, fmt.Sprintf("if [ -d %[1]s ] && [ ! -L %[1]s ]; then rmdir -- %[1]s; else rm -- %[1]s; fi", quoted))
+	return err
+}
+
+func (shell shellFS) RemoveAll(remotePath string) error {
+	quoted := shellQuote(remotePath)
+	_, err := shell.run("remove", remotePath, fmt.Sprintf("if [ ! -e %[1]s ] && [ ! -L %[1]s ]; then echo 'No such file or directory' >&2; exit 1; fi; rm -rf -- %[1]s", quoted))
+	return err
+}
+
+func (shell shellFS) Rename(oldPath string, newPath string) error {
+	_, err := shell.run("rename", oldPath, "mv -T -- "+shellQuote(oldPath)+" "+shellQuote(newPath))
+	return err
+}
+
+func (shell shellFS) PosixRename(oldPath string, newPath string) error {
+	return shell.Rename(oldPath, newPath)
+}
+
+func (shell shellFS) Chmod(remotePath string, mode os.FileMode) error {
+	_, err := shell.run("chmod", remotePath, fmt.Sprintf("chmod %o -- %s", octalMode(mode), shellQuote(remotePath)))
+	return err
+}
+
+func (shell shellFS) Chown(remotePath string, uid int, gid int) error {
+	owner := ""
+	if uid >= 0 {
+		owner = strconv.Itoa(uid)
+	}
+	if gid >= 0 {
+		owner += ":" + strconv.Itoa(gid)
+	}
+	_, err := shell.run("chown", remotePath, "chown -- "+owner+" "+shellQuote(remotePath))
+	return err
+}
+
+func (shell shellFS) Chtimes(remotePath string, atime time.Time, mtime time.Time) error {
+	quoted := shellQuote(remotePath)
+	_, err := shell.run("chtimes", remotePath, fmt.Sprintf("touch -c -a -d @%d -- %s && touch -c -m -d @%d -- %s", atime.Unix(), quoted, mtime.Unix(), quoted))
+	return err
+}
+
+func (shell shellFS) Getwd() (string, error) {
+	output, err := shell.run("getwd", ".", "pwd")
+	return strings.TrimSpace(output), err
+}
+
+func (shell shellFS) FreeSpace(remotePath string) (uint64, error) {
+	output, err := shell.run("df", remotePath, "df -Pk -- "+shellQuote(remotePath))
+	if err != nil {
+		return 0, err
+	}
+
+	// The second line holds the file system, its fourth column the available kilobytes
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(lines) < 2 || len(fields) < 4 {
+		return 0, fmt.Errorf("unexpected df output %q", output)
+	}
+	available, err := strconv.ParseUint(fields[3], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return available * 1024, nil
+}
+
+func (shell shellFS) Close() error {
+	return nil
+}
+
+// commandStream - Reader or writer connected to a command running on the host, its exit status is reported on EOF or Close
+type commandStream struct {
+	operation  string
+	remotePath string
+	session    *ssh.Session
+	stdin      io.WriteCloser
+	stdout     io.Reader
+	stderr     strings.Builder
+	written    int64
+	size       int64
+	scp        bool
+	finished   bool
+}
+
+// startCommand - Start a command with its stdin and stdout connected to the stream
+func (shell shellFS) startCommand(operation string, remotePath string, command string) (*commandStream, error) {
+	session, err := shell.ssh.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	stream := &commandStream{operation: operation, remotePath: remotePath, session: session}
+	session.Stderr = &stream.stderr
+	if stream.stdin, err = session.StdinPipe(); err == nil {
+		stream.stdout, err = session.StdoutPipe()
+	}
+	if err == nil {
+		err = session.Start(command)
+	}
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	return stream, nil
+}
+
+// wait - Wait for the command to exit
+func (stream *commandStream) wait() error {
+	if stream.finished {
+		return nil
+	}
+	stream.finished = true
+
+	defer stream.session.Close()
+	if err := stream.session.Wait(); err != nil {
+		return shellError(stream.operation, stream.remotePath, strings.TrimSpace(stream.stderr.String()), err)
+	}
+
+	return nil
+}
+
+func (stream *commandStream) Read(buffer []byte) (int, error) {
+	readBytes, err := stream.stdout.Read(buffer)
+	if err == io.EOF {
+		if waitErr := stream.wait(); waitErr != nil {
+			return readBytes, waitErr
+		}
+	}
+
+	return readBytes, err
+}
+
+func (stream *commandStream) Write(buffer []byte) (int, error) {
+	writtenBytes, err := stream.stdin.Write(buffer)
+	stream.written += int64(writtenBytes)
+	return writtenBytes, err
+}
+
+// Close - Finish the input and wait for the command, for SCP the transfer is confirmed first
+func (stream *commandStream) Close() error {
+	if stream.finished {
+		return nil
+	}
+
+	if stream.scp {
+		if stream.written != stream.size {
+			stream.stdin.Close()
+			stream.wait()
+			return fmt.Errorf("scp of %s stopped after %d of %d bytes", stream.remotePath, stream.written, stream.size)
+		}
+		if _, err := stream.stdin.Write([]byte{0}); err != nil {
+			return err
+		}
+		if err := readSCPAck(stream.stdout); err != nil {
+			stream.stdin.Close()
+			stream.wait()
+			return err
+		}
+	}
+
+	stream.stdin.Close()
+	io.Copy(io.Discard, stream.stdout)
+	return stream.wait()
+}
+
+func (shell shellFS) Open(remotePath string) (io.ReadCloser, error) {
+	stream, err := shell.startCommand("open", remotePath, "cat -- "+shellQuote(remotePath))
+	if err != nil {
+		return nil, err
+	}
+	stream.stdin.Close()
+
+	return stream, nil
+}
+
+func (shell shellFS) OpenWriter(remotePath string, offset int64, size int64, mode os.FileMode) (io.WriteCloser, int64, error) {
+	if shell.scp {
+		return shell.openSCPWriter(remotePath, size, mode)
+	}
+
+	command := "cat > " + shellQuote(remotePath)
+	if offset > 0 {
+		command = fmt.Sprintf("truncate -s %d -- %s && cat >> %s", offset, shellQuote(remotePath), shellQuote(remotePath))
+	}
+
+	stream, err := shell.startCommand("write", remotePath, command)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return stream, offset, nil
+}
+
+// openSCPWriter - Send a file with the sink side of the SCP protocol, which always starts from the beginning
+// and creates the file with the permissions given in the C line
+func (shell shellFS) openSCPWriter(remotePath string, size int64, mode os.FileMode) (io.WriteCloser, int64, error) {
+	stream, err := shell.startCommand("scp", remotePath, "scp -t "+shellQuote(remotePath))
+	if err != nil {
+		return nil, 0, err
+	}
+	stream.scp = true
+	stream.size = size
+
+	reader := bufio.NewReader(stream.stdout)
+	stream.stdout = reader
+	if err = readSCPAck(reader); err == nil {
+		if _, err = fmt.Fprintf(stream.stdin, "C%04o %d %s\n", mode.Perm(), size, path.Base(remotePath)); err == nil {
+			err = readSCPAck(reader)
+		}
+	}
+	if err != nil {
+		stream.stdin.Close()
+		stream.wait()
+		return nil, 0, err
+	}
+
+	return stream, 0, nil
+}
+
+// readSCPAck - Read the status byte the SCP sink answers every step with
+func readSCPAck(reader io.Reader) error {
+	status := make([]byte, 1)
+	if _, err := io.ReadFull(reader, status); err != nil {
+		return fmt.Errorf("scp: %w", err)
+	}
+	if status[0] == 0 {
+		return nil
+	}
+
+	message, _ := bufio.NewReader(reader).ReadString('\n')
+	return fmt.Errorf("scp: %s", strings.TrimSpace(message))
+}
@@ -0,0 +1,66 @@
+package helpers
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseStat(t *testing.T) {
+	output := "12 81a4 1700000000 ./src/main file.go\x00" +
+		"4096 41ed 1700000001 ./src\x00" +
+		"7 a1ff 1700000002 ./link\x00"
+
+	infos, err := parseStat(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		name    string
+		size    int64
+		mode    os.FileMode
+		modTime int64
+	}{
+		{"main file.go", 12, 0644, 1700000000},
+		{"src", 4096, os.ModeDir | 0755, 1700000001},
+		{"link", 7, os.ModeSymlink | 0777, 1700000002},
+	}
+	if len(infos) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(infos), len(want))
+	}
+	for index, info := range infos {
+		if info.Name() != want[index].name || info.Size() != want[index].size || info.Mode() != want[index].mode || !info.ModTime().Equal(time.Unix(want[index].modTime, 0)) {
+			t.Errorf("entry %d is %s %d %s %s, want %+v", index, info.Name(), info.Size(), info.Mode(), info.ModTime(), want[index])
+		}
+	}
+
+	for _, output := range []string{"12 81a4 1700000000\x00", "x 81a4 1700000000 a\x00", "12 zz 1700000000 a\x00"} {
+		if _, err := parseStat(output); err == nil {
+			t.Errorf("parseStat(%q) didn't fail", output)
+		}
+	}
+}
+
+func TestRawFileMode(t *testing.T) {
+	tests := []struct {
+		rawMode uint32
+		want    os.FileMode
+	}{
+		{0100644, 0644},
+		{0104755, os.ModeSetuid | 0755},
+		{0102755, os.ModeSetgid | 0755},
+		{0041777, os.ModeDir | os.ModeSticky | 0777},
+		{0120777, os.ModeSymlink | 0777},
+		{0060660, os.ModeIrregular | 0660},
+	}
+
+	for _, test := range tests {
+		if got := rawFileMode(test.rawMode); got != test.want {
+			t.Errorf("rawFileMode(%o) = %s, want %s", test.rawMode, got, test.want)
+		}
+		if got := octalMode(rawFileMode(test.rawMode)); got != test.rawMode&07777 {
+			t.Errorf("octalMode(rawFileMode(%o)) = %o, want %o", test.rawMode, got, test.rawMode&07777)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package helpers
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const trashDirName = ".fsync-trash"
+const trashBatchFormat = "2006-01-02T15-04-05"
+
+// Supported values of delete_policy
+const (
+	DeletePolicyDelete = "delete"
+	DeletePolicyTrash  = "trash"
+	DeletePolicyIgnore = "ignore"
+)
+
+// trashDir - Remote directory which holds the trashed files of a host
+func (singleHost hostObject) trashDir() string {
+	return path.Join(singleHost.RemoteDir, trashDirName)
+}
+
+// removeRemote - Apply the delete policy to a path relative to LocalDir which was removed locally
+func (session *syncSession) removeRemote(relPath string) error {
+	remotePath := session.Host.remotePath(relPath)
+	start := time.Now()
+
+	switch session.Host.DeletePolicy {
+	case DeletePolicyIgnore:
+		fmt.Printf("[%s] Keeping %s on the remote\n", session.PetName, relPath)
+		return nil
+	case DeletePolicyTrash:
+		trashPath := path.Join(session.Host.trashDir(), time.Now().Format(trashBatchFormat), relPath)
+		err := session.withRetry("trash "+relPath, func() error {
+			if err := session.Remote.FS.MkdirAll(path.Dir(trashPath)); err != nil {
+				return err
+			}
+			return session.Remote.FS.Rename(remotePath, trashPath)
+		})
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err == nil {
+			fmt.Printf("[%s] Moved %s to %s\n", session.PetName, relPath, trashPath)
+		}
+		session.record("trash", relPath, remotePath, 0, start, "", err)
+		return err
+	default:
+		err := session.withRetry("delete "+relPath, func() error {
+			return session.Remote.FS.RemoveAll(remotePath)
+		})
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err == nil {
+			fmt.Printf("[%s] Deleted %s\n", session.PetName, relPath)
+		}
+		session.record("delete", relPath, remotePath, 0, start, "", err)
+		return err
+	}
+}
+
+// trashEntries - Files in the trash of a host as batch/relative path
+func (singleHost hostObject) trashEntries(remote *remoteHost) ([]treeEntry, error) {
+	var entries []treeEntry
+
+	walker := remote.FS.Walk(singleHost.trashDir())
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if os.IsNotExist(err) && walker.Path() == singleHost.trashDir() {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if walker.Stat().IsDir() {
+			continue
+		}
+
+		info := walker.Stat()
+		relPath := strings.TrimPrefix(walker.Path(), singleHost.trashDir()+"/")
+		entries = append(entries, treeEntry{relPath, info.Size(), info.ModTime(), info.Mode(), false})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].RelPath < entries[j].RelPath })
+
+	return entries, nil
+}
+
+// TrashAction - List, restore or purge the remote trash of a host
+func (hosts HostConfig) TrashAction(action string, petName string, items []string) {
+	hostData := hosts.lookupHost(petName)
+
+	remote, err := hosts.connectHost(&hostData)
+	if err != nil {
+		fmt.Printf("[%s] Encountered error while connecting: %s\n", petName, err)
+		os.Exit(1)
+	}
+	defer remote.Close()
+
+	switch action {
+	case "list":
+		entries, err := hostData.trashEntries(remote)
+		if err != nil {
+			fmt.Printf("[%s] Unable to read the trash: %s\n", petName, err)
+			os.Exit(1)
+		}
+		if len(entries) == 0 {
+			fmt.Printf("[%s] Trash is empty\n", petName)
+			return
+		}
+		for _, entry := range entries {
+			fmt.Printf("%s\t%d bytes\n", entry.RelPath, entry.Size)
+		}
+	case "restore":
+		if len(items) == 0 {
+			fmt.Println("Select the items to restore with -p <batch>/<path>, see `trash list`")
+			os.Exit(1)
+		}
+
+		failed := false
+		for _, item := range items {
+			batch, relPath, found := strings.Cut(path.Clean(item), "/")
+			if !found || batch == ".." || relPath == "" || strings.HasPrefix(relPath, "../") {
+				fmt.Printf("[%s] Invalid trash item %s\n", petName, item)
+				failed = true
+				continue
+			}
+
+			remotePath := hostData.remotePath(relPath)
+			if _, err = remote.FS.Lstat(remotePath); err == nil {
+				fmt.Printf("[%s] Not restoring %s, %s already exists\n", petName, item, remotePath)
+				failed = true
+				continue
+			}
+
+			start := time.Now()
+			err = remote.createRemoteDirs(hostData, path.Dir(remotePath))
+			if err == nil {
+				err = remote.FS.Rename(path.Join(hostData.trashDir(), batch, relPath), remotePath)
+			}
+			hosts.History.record(historyEntry{Time: start.UTC(), Host: petName, Operation: "restore", RemotePath: remotePath, DurationMs: time.Since(start).Milliseconds(), Error: errorString(err)})
+			if err != nil {
+				fmt.Printf("[%s] Unable to restore %s: %s\n", petName, item, err)
+				failed = true
+				continue
+			}
+			fmt.Printf("[%s] Restored %s\n", petName, remotePath)
+		}
+		if failed {
+			os.Exit(1)
+		}
+	case "purge":
+		targets := []string{hostData.trashDir()}
+		if len(items) > 0 {
+			targets = nil
+			for _, item := range items {
+				cleanItem := path.Clean(item)
+				if cleanItem == "." || cleanItem == ".." || strings.HasPrefix(cleanItem, "../") || path.IsAbs(cleanItem) {
+					fmt.Printf("[%s] Invalid trash item %s\n", petName, item)
+					os.Exit(1)
+				}
+				targets = append(targets, path.Join(hostData.trashDir(), cleanItem))
+			}
+		}
+
+		for _, target := range targets {
+			start := time.Now()
+			err = remote.FS.RemoveAll(target)
+			if os.IsNotExist(err) {
+				err = nil
+			}
+			hosts.History.record(historyEntry{Time: start.UTC(), Host: petName, Operation: "purge", RemotePath: target, DurationMs: time.Since(start).Milliseconds(), Error: errorString(err)})
+			if err != nil {
+				fmt.Printf("[%s] Unable to purge %s: %s\n", petName, target, err)
+				os.Exit(1)
+			}
+			fmt.Printf("[%s] Purged %s\n", petName, target)
+		}
+	default:
+		fmt.Println("Unknown trash action, supported are list, restore and purge")
+		os.Exit(1)
+	}
+}
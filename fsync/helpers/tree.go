@@ -0,0 +1,236 @@
+package helpers
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// treeEntry - Single file or directory relative to the root of a synced tree
+type treeEntry struct {
+	RelPath string
+	Size    int64
+	ModTime time.Time
+	Mode    fs.FileMode
+	IsDir   bool
+}
+
+// isIgnored - Check a slash separated path relative to the synced root against the ignore rules.
+// Patterns containing a slash are matched against the whole path, the rest against every path element.
+func (singleHost hostObject) isIgnored(relPath string) bool {
+	relPath = strings.Trim(filepath.ToSlash(relPath), "/")
+	if relPath == "" || relPath == "." {
+		return false
+	}
+
+	// Temp files of in-flight transfers and editor saves are never synced themselves
+	if strings.HasSuffix(relPath, partialSuffix) || strings.HasSuffix(relPath, downloadSuffix) {
+		return true
+	}
+
+	elements := strings.Split(relPath, "/")
+	if elements[0] == trashDirName || strings.HasPrefix(elements[0], stagingPrefix) || isEditorArtefact(elements[len(elements)-1]) {
+		return true
+	}
+	if singleHost.GitMode && elements[0] == ".git" {
+		return true
+	}
+
+	if !singleHost.isIncluded(elements) {
+		return true
+	}
+
+	for _, pattern := range singleHost.Ignore {
+		pattern = strings.TrimSuffix(pattern, "/")
+		if strings.Contains(pattern, "/") {
+			pattern = strings.TrimPrefix(pattern, "/")
+			for i := range elements {
+				if matched, _ := path.Match(pattern, strings.Join(elements[:i+1], "/")); matched {
+					return true
+				}
+			}
+			continue
+		}
+
+		for _, element := range elements {
+			if matched, _ := path.Match(pattern, element); matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isIncluded - Check the path elements against the include patterns, everything is included without any.
+// Each pattern is matched element by element from LocalDir, so src includes everything below src/ and
+// the parent directories of a pattern are included to reach it.
+func (singleHost hostObject) isIncluded(elements []string) bool {
+	if len(singleHost.Include) == 0 {
+		return true
+	}
+
+	for _, pattern := range singleHost.Include {
+		patternElements := strings.Split(strings.Trim(pattern, "/"), "/")
+		matched := true
+		for i := 0; i < len(elements) && i < len(patternElements); i++ {
+			if ok, _ := path.Match(patternElements[i], elements[i]); !ok {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkFileSize - Error for a file larger than max_file_size
+func (singleHost hostObject) checkFileSize(size int64) error {
+	if singleHost.maxFileSize == 0 || size <= singleHost.maxFileSize {
+		return nil
+	}
+
+	return fmt.Errorf("%d bytes exceeds max_file_size %s", size, singleHost.MaxFileSize)
+}
+
+// tooLarge - Check a file size against max_file_size, warning about files which are skipped
+func (session *syncSession) tooLarge(relPath string, size int64) bool {
+	err := session.Host.checkFileSize(size)
+	if err != nil {
+		fmt.Printf("[%s] Skipping %s, %s\n", session.PetName, relPath, err)
+	}
+
+	return err != nil
+}
+
+// parseSize - Parse a size in bytes with an optional K, M, G or T suffix
+func parseSize(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	number := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(value)), "B")
+	for index, suffix := range []string{"K", "M", "G", "T"} {
+		if trimmed, found := strings.CutSuffix(number, suffix); found {
+			number = trimmed
+			multiplier = int64(1) << (10 * (index + 1))
+			break
+		}
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(number), 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size %q, use a number of bytes with an optional K, M, G or T suffix", value)
+	}
+
+	return size * multiplier, nil
+}
+
+// relativePath - Path of a local file relative to LocalDir, using forward slashes
+func (singleHost hostObject) relativePath(localPath string) (string, error) {
+	relPath, err := filepath.Rel(singleHost.LocalDir, localPath)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.ToSlash(relPath), nil
+}
+
+// localPath - Local location of a path relative to LocalDir
+func (singleHost hostObject) localPath(relPath string) string {
+	return filepath.Join(singleHost.LocalDir, filepath.FromSlash(relPath))
+}
+
+// remotePath - Remote location of a path relative to LocalDir
+func (singleHost hostObject) remotePath(relPath string) string {
+	return path.Join(singleHost.RemoteDir, relPath)
+}
+
+// listLocalTree - Collect all entries under LocalDir which aren't ignored, in git mode only the ones git would ship
+func (singleHost hostObject) listLocalTree() (map[string]treeEntry, error) {
+	if singleHost.GitMode {
+		return singleHost.listGitTree()
+	}
+
+	entries := make(map[string]treeEntry)
+
+	err := filepath.WalkDir(singleHost.LocalDir, func(walkPath string, dirEntry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && walkPath == singleHost.LocalDir {
+				return filepath.SkipAll
+			}
+			return err
+		}
+
+		relPath, err := singleHost.relativePath(walkPath)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+		if singleHost.isIgnored(relPath) {
+			if dirEntry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := dirEntry.Info()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+
+		entries[relPath] = treeEntry{relPath, info.Size(), info.ModTime(), info.Mode(), info.IsDir()}
+		return nil
+	})
+
+	return entries, err
+}
+
+// listRemoteTree - Collect all entries under RemoteDir which aren't ignored
+func (singleHost hostObject) listRemoteTree(client remoteFS) (map[string]treeEntry, error) {
+	entries := make(map[string]treeEntry)
+
+	walker := client.Walk(singleHost.RemoteDir)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if os.IsNotExist(err) && walker.Path() == singleHost.RemoteDir {
+				return entries, nil
+			}
+			return nil, err
+		}
+
+		relPath := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), singleHost.RemoteDir), "/")
+		if relPath == "" {
+			continue
+		}
+		if singleHost.isIgnored(relPath) {
+			if walker.Stat().IsDir() {
+				walker.SkipDir()
+			}
+			continue
+		}
+
+		info := walker.Stat()
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			continue
+		}
+
+		entries[relPath] = treeEntry{relPath, info.Size(), info.ModTime(), info.Mode(), info.IsDir()}
+	}
+
+	return entries, nil
+}
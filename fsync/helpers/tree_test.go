@@ -0,0 +1,65 @@
+package helpers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsIncluded(t *testing.T) {
+	tests := []struct {
+		include []string
+		relPath string
+		want    bool
+	}{
+		{nil, "anything/at/all", true},
+		{[]string{"src"}, "src", true},
+		{[]string{"src"}, "src/pkg/main.go", true},
+		{[]string{"src"}, "docs/index.md", false},
+		{[]string{"/src/"}, "src/main.go", true},
+		{[]string{"src/*.go"}, "src", true},
+		{[]string{"src/*.go"}, "src/main.go", true},
+		{[]string{"src/*.go"}, "src/notes.txt", false},
+		{[]string{"cmd/*/main.go"}, "cmd/fsync", true},
+		{[]string{"cmd/*/main.go"}, "cmd/fsync/main.go", true},
+		{[]string{"cmd/*/main.go"}, "cmd/fsync/util.go", false},
+		{[]string{"docs", "src"}, "src/main.go", true},
+		{[]string{"docs", "src"}, "test/main_test.go", false},
+	}
+
+	for _, test := range tests {
+		singleHost := hostObject{Include: test.include}
+		if got := singleHost.isIncluded(strings.Split(test.relPath, "/")); got != test.want {
+			t.Errorf("include %v, isIncluded(%q) = %t, want %t", test.include, test.relPath, got, test.want)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+	}{
+		{"", 0},
+		{"0", 0},
+		{"512", 512},
+		{"10K", 10 << 10},
+		{"10kb", 10 << 10},
+		{"5M", 5 << 20},
+		{" 2 G ", 2 << 30},
+		{"1T", 1 << 40},
+		{"100B", 100},
+	}
+
+	for _, test := range tests {
+		got, err := parseSize(test.value)
+		if err != nil || got != test.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", test.value, got, err, test.want)
+		}
+	}
+
+	for _, value := range []string{"-1", "1.5M", "ten", "5P", "M"} {
+		if _, err := parseSize(value); err == nil {
+			t.Errorf("parseSize(%q) didn't fail", value)
+		}
+	}
+}
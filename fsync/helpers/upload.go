@@ -0,0 +1,371 @@
+package helpers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pkg/sftp"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const uploadStateFileName = "uploads.json"
+const uploadChunkSize = 1 << 20
+const partialSuffix = ".fsync-part"
+
+// Supported values of verify
+const (
+	VerifyNone = "none"
+	VerifySize = "size"
+	VerifyHash = "hash"
+)
+
+// partialUpload - Upload which hasn't been renamed into place yet
+type partialUpload struct {
+	Host       string    `json:"host"`
+	LocalPath  string    `json:"local_path"`
+	RemotePath string    `json:"remote_path"`
+	TempPath   string    `json:"temp_path"`
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"mod_time"`
+	Offset     int64     `json:"offset"`
+}
+
+// uploadState - Partial uploads of all hosts, persisted in the state directory
+type uploadState struct {
+	path     string
+	mutex    sync.Mutex
+	Partials map[string]partialUpload `json:"partials"`
+}
+
+// loadUploadState - Read the partial uploads left over from a previous run
+func loadUploadState(stateDir string) (*uploadState, error) {
+	state := &uploadState{path: filepath.Join(stateDir, uploadStateFileName), Partials: make(map[string]partialUpload)}
+
+	data, err := os.ReadFile(state.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", state.path, err)
+	}
+	if state.Partials == nil {
+		state.Partials = make(map[string]partialUpload)
+	}
+
+	return state, nil
+}
+
+// get - Look up the partial upload of a remote path
+func (state *uploadState) get(petName string, remotePath string) (partialUpload, bool) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	partial, ok := state.Partials[petName+":"+remotePath]
+	return partial, ok
+}
+
+// pending - All partial uploads of a host
+func (state *uploadState) pending(petName string) []partialUpload {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	var result []partialUpload
+	for _, partial := range state.Partials {
+		if partial.Host == petName {
+			result = append(result, partial)
+		}
+	}
+
+	return result
+}
+
+// set - Record the confirmed progress of an upload
+func (state *uploadState) set(partial partialUpload) error {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	state.Partials[partial.Host+":"+partial.RemotePath] = partial
+	return state.save()
+}
+
+// remove - Forget an upload once it is finished or abandoned
+func (state *uploadState) remove(petName string, remotePath string) error {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	if _, ok := state.Partials[petName+":"+remotePath]; !ok {
+		return nil
+	}
+	delete(state.Partials, petName+":"+remotePath)
+	return state.save()
+}
+
+// save - Write the state file, the mutex needs to be held by the caller
+func (state *uploadState) save() error {
+	return writeStateFile(state.path, state)
+}
+
+// writeStateFile - Atomically replace a file in the state directory with the value as JSON
+func writeStateFile(filePath string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := filePath + ".tmp"
+	if err = os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, filePath)
+}
+
+// partialPath - Remote temp file used while a file is being uploaded
+func partialPath(remotePath string) string {
+	return path.Join(path.Dir(remotePath), fmt.Sprintf(".%s%s", path.Base(remotePath), partialSuffix))
+}
+
+// uploadFile - Upload a file relative to LocalDir, resuming where a failed attempt stopped
+func (session *syncSession) uploadFile(relPath string) error {
+	var (
+		checksum string
+		size     int64
+		err      error
+	)
+
+	start := time.Now()
+	if info, statErr := os.Stat(session.Host.localPath(relPath)); statErr == nil {
+		size = info.Size()
+	}
+	defer func() {
+		board.update(session.PetName, func(status *hostStatus) { status.Transfer = "" })
+		session.record("upload", relPath, session.Host.remotePath(relPath), size, start, checksum, err)
+	}()
+
+	err = session.withRetry("upload "+relPath, func() error {
+		var attemptErr error
+		checksum, attemptErr = session.uploadOnce(relPath)
+		return attemptErr
+	})
+
+	return err
+}
+
+// uploadOnce - Single attempt of uploading a file into its temp path, verifying it and renaming it into place.
+// The SHA-256 of the uploaded content is returned.
+func (session *syncSession) uploadOnce(relPath string) (string, error) {
+	if session.Remote == nil {
+		return "", errors.New("not connected")
+	}
+
+	client := session.Remote.FS
+	localPath := session.Host.localPath(relPath)
+	remotePath := session.Host.remotePath(relPath)
+	uploads := session.Config.Uploads
+
+	localFile, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer localFile.Close()
+
+	info, err := localFile.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	partial, resumed := uploads.get(session.PetName, remotePath)
+	if !resumed || partial.LocalPath != localPath || partial.Size != info.Size() || !partial.ModTime.Equal(info.ModTime()) {
+		partial = partialUpload{
+			Host:       session.PetName,
+			LocalPath:  localPath,
+			RemotePath: remotePath,
+			TempPath:   partialPath(remotePath),
+			Size:       info.Size(),
+			ModTime:    info.ModTime(),
+		}
+	}
+
+	if err = session.Remote.createRemoteDirs(session.Host, path.Dir(remotePath)); err != nil {
+		return "", err
+	}
+
+	if partial.Offset > 0 {
+		remoteInfo, err := client.Stat(partial.TempPath)
+		if err != nil || remoteInfo.Size() < partial.Offset {
+			partial.Offset = 0
+		}
+	}
+
+	// Anything past the confirmed offset may be incomplete, so it is written again.
+	// Transports which can't resume start over from the beginning.
+	remoteFile, offset, err := client.OpenWriter(partial.TempPath, partial.Offset, partial.Size, info.Mode())
+	if err != nil {
+		return "", err
+	}
+	defer remoteFile.Close()
+
+	partial.Offset = offset
+	if partial.Offset > 0 {
+		fmt.Printf("[%s] Resuming upload of %s at %d/%d bytes\n", session.PetName, relPath, partial.Offset, partial.Size)
+	}
+
+	hasher := sha256.New()
+	if _, err = io.CopyN(hasher, localFile, partial.Offset); err != nil {
+		return "", err
+	}
+	if err = uploads.set(partial); err != nil {
+		return "", err
+	}
+
+	buffer := make([]byte, uploadChunkSize)
+	for partial.Offset < partial.Size {
+		readBytes, readErr := localFile.Read(buffer)
+		if readBytes > 0 {
+			if _, err = remoteFile.Write(buffer[:readBytes]); err != nil {
+				return "", err
+			}
+			hasher.Write(buffer[:readBytes])
+			partial.Offset += int64(readBytes)
+			if err = uploads.set(partial); err != nil {
+				return "", err
+			}
+			board.update(session.PetName, func(status *hostStatus) {
+				status.Transfer, status.Sent, status.Total = relPath, partial.Offset, partial.Size
+			})
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return "", readErr
+		}
+	}
+
+	if err = remoteFile.Close(); err != nil {
+		return "", err
+	}
+
+	if currentInfo, err := os.Stat(localPath); err != nil || currentInfo.Size() != partial.Size || !currentInfo.ModTime().Equal(partial.ModTime) || partial.Offset != partial.Size {
+		uploads.remove(session.PetName, remotePath)
+		client.Remove(partial.TempPath)
+		return "", fmt.Errorf("%s changed during upload", localPath)
+	}
+
+	localSum := hex.EncodeToString(hasher.Sum(nil))
+	if err = session.verifyUpload(relPath, partial.TempPath, partial.Size, localSum); err != nil {
+		uploads.remove(session.PetName, remotePath)
+		client.Remove(partial.TempPath)
+		return "", err
+	}
+
+	if err = session.Host.applyRemoteMetadata(client, partial.TempPath, info); err != nil {
+		return "", err
+	}
+	if err = session.Remote.applyOwnership(session.Host, partial.TempPath, false); err != nil {
+		return "", err
+	}
+
+	if err = replaceRemote(client, partial.TempPath, remotePath); err != nil {
+		return "", err
+	}
+
+	return localSum, uploads.remove(session.PetName, remotePath)
+}
+
+// replaceRemote - Rename a file over an existing one. Only servers without the posix-rename extension get the
+// destination removed first, any other failure leaves it in place.
+func replaceRemote(client remoteFS, oldPath string, newPath string) error {
+	err := client.PosixRename(oldPath, newPath)
+	var statusErr *sftp.StatusError
+	if !errors.As(err, &statusErr) || statusErr.FxCode() != sftp.ErrSSHFxOpUnsupported {
+		return err
+	}
+
+	client.Remove(newPath)
+	return client.Rename(oldPath, newPath)
+}
+
+// verifyUpload - Confirm the uploaded temp file matches the local one as configured with verify.
+// A mismatch is returned as a transient error, so the upload is retried.
+func (session *syncSession) verifyUpload(relPath string, tempPath string, size int64, localSum string) error {
+	switch session.Host.Verify {
+	case VerifyNone:
+		return nil
+	case VerifySize:
+		info, err := session.Remote.FS.Stat(tempPath)
+		if err != nil {
+			return err
+		}
+		if info.Size() != size {
+			return fmt.Errorf("size mismatch for %s: local %d, remote %d", relPath, size, info.Size())
+		}
+		return nil
+	}
+
+	remoteSum, err := session.Remote.checksum(tempPath)
+	if err != nil {
+		return err
+	}
+	if localSum != remoteSum {
+		return fmt.Errorf("checksum mismatch for %s: local %s, remote %s", relPath, localSum, remoteSum)
+	}
+
+	return nil
+}
+
+// checksum - SHA-256 of a remote file, using sha256sum when available and streaming it otherwise
+func (remote *remoteHost) checksum(remotePath string) (string, error) {
+	output, err := remote.runCommand("sha256sum -- " + shellQuote(remotePath))
+	if err == nil {
+		if fields := strings.Fields(output); len(fields) > 0 && len(fields[0]) == sha256.Size*2 {
+			return fields[0], nil
+		}
+	}
+
+	return remote.streamChecksum(remotePath)
+}
+
+// streamChecksum - SHA-256 of a remote file, computed locally by reading it over the transport
+func (remote *remoteHost) streamChecksum(remotePath string) (string, error) {
+	remoteFile, err := remote.FS.Open(remotePath)
+	if err != nil {
+		return "", err
+	}
+	defer remoteFile.Close()
+
+	hasher := sha256.New()
+	if _, err = io.Copy(hasher, remoteFile); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// applyRemoteMetadata - Copy the mode and modification time of a local file when enabled
+func (singleHost hostObject) applyRemoteMetadata(client remoteFS, remotePath string, info os.FileInfo) error {
+	if singleHost.PreserveMode {
+		if err := client.Chmod(remotePath, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	if singleHost.PreserveTimes {
+		if err := client.Chtimes(remotePath, info.ModTime(), info.ModTime()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
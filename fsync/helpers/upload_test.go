@@ -0,0 +1,55 @@
+package helpers
+
+import (
+	"github.com/pkg/sftp"
+	"os"
+	"strings"
+	"testing"
+)
+
+// renameFS - Transport which only records renames and removes
+type renameFS struct {
+	remoteFS
+	posixErr error
+	calls    []string
+}
+
+func (client *renameFS) PosixRename(oldPath string, newPath string) error {
+	client.calls = append(client.calls, "posix-rename")
+	return client.posixErr
+}
+
+func (client *renameFS) Remove(remotePath string) error {
+	client.calls = append(client.calls, "remove")
+	return nil
+}
+
+func (client *renameFS) Rename(oldPath string, newPath string) error {
+	client.calls = append(client.calls, "rename")
+	return nil
+}
+
+func TestReplaceRemote(t *testing.T) {
+	tests := []struct {
+		name     string
+		posixErr error
+		wantErr  bool
+		want     string
+	}{
+		{"posix rename works", nil, false, "posix-rename"},
+		{"extension unsupported", &sftp.StatusError{Code: uint32(sftp.ErrSSHFxOpUnsupported)}, false, "posix-rename remove rename"},
+		{"permission denied", &sftp.StatusError{Code: uint32(sftp.ErrSSHFxPermissionDenied)}, true, "posix-rename"},
+		{"connection lost", os.ErrClosed, true, "posix-rename"},
+	}
+
+	for _, test := range tests {
+		client := &renameFS{posixErr: test.posixErr}
+		err := replaceRemote(client, "/remote/.file.fsync-part", "/remote/file")
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: error %v, want error %t", test.name, err, test.wantErr)
+		}
+		if calls := strings.Join(client.calls, " "); calls != test.want {
+			t.Errorf("%s: calls %q, want %q", test.name, calls, test.want)
+		}
+	}
+}
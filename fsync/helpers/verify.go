@@ -0,0 +1,290 @@
+package helpers
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Exit codes of the verify action
+const (
+	VerifyInSync = 0
+	VerifyDrift  = 1
+	VerifyFailed = 2
+)
+
+// metadataMismatch - Metadata which differs between the local and the remote copy
+type metadataMismatch struct {
+	Path   string `json:"path"`
+	Field  string `json:"field"`
+	Local  string `json:"local"`
+	Remote string `json:"remote"`
+}
+
+// driftReport - Differences between LocalDir and RemoteDir of a single host
+type driftReport struct {
+	InSync   bool               `json:"in_sync"`
+	Missing  []string           `json:"missing"`
+	Extra    []string           `json:"extra"`
+	Differs  []string           `json:"differs"`
+	Metadata []metadataMismatch `json:"metadata"`
+	Error    string             `json:"error,omitempty"`
+}
+
+// VerifyContent - Compare LocalDir and RemoteDir of the selected hosts without changing anything and return the exit code
+func (hosts HostConfig) VerifyContent(jsonOutput bool) int {
+	var petNames []string
+
+	for hostPetName := range hosts.HostsMap {
+		petNames = append(petNames, hostPetName)
+	}
+	sort.Strings(petNames)
+
+	exitCode := VerifyInSync
+	reports := make(map[string]driftReport)
+	for _, hostPetName := range petNames {
+		report, err := hosts.driftReport(hostPetName)
+		if err != nil {
+			report.Error = err.Error()
+			exitCode = VerifyFailed
+		} else if !report.InSync && exitCode == VerifyInSync {
+			exitCode = VerifyDrift
+		}
+		reports[hostPetName] = report
+	}
+
+	if jsonOutput {
+		output, _ := json.MarshalIndent(map[string]interface{}{"in_sync": exitCode == VerifyInSync, "hosts": reports}, "", "  ")
+		fmt.Println(string(output))
+		return exitCode
+	}
+
+	for _, hostPetName := range petNames {
+		report := reports[hostPetName]
+		if report.Error != "" {
+			fmt.Printf("[%s] Unable to verify: %s\n", hostPetName, report.Error)
+			continue
+		}
+
+		for _, relPath := range report.Missing {
+			fmt.Printf("[%s] missing on remote: %s\n", hostPetName, relPath)
+		}
+		for _, relPath := range report.Extra {
+			fmt.Printf("[%s] extra on remote: %s\n", hostPetName, relPath)
+		}
+		for _, relPath := range report.Differs {
+			fmt.Printf("[%s] content differs: %s\n", hostPetName, relPath)
+		}
+		for _, mismatch := range report.Metadata {
+			fmt.Printf("[%s] %s differs: %s (local %s, remote %s)\n", hostPetName, mismatch.Field, mismatch.Path, mismatch.Local, mismatch.Remote)
+		}
+
+		if report.InSync {
+			fmt.Printf("[%s] In sync\n", hostPetName)
+		} else {
+			fmt.Printf("[%s] Drift found: %d missing, %d extra, %d differing, %d metadata\n", hostPetName, len(report.Missing), len(report.Extra), len(report.Differs), len(report.Metadata))
+		}
+	}
+
+	return exitCode
+}
+
+// driftReport - Walk both trees of a host and compare them by content hash
+func (hosts HostConfig) driftReport(petName string) (driftReport, error) {
+	report := driftReport{Missing: []string{}, Extra: []string{}, Differs: []string{}, Metadata: []metadataMismatch{}}
+	hostData := hosts.HostsMap[petName]
+
+	remote, err := hosts.connectHost(&hostData)
+	if err != nil {
+		return report, err
+	}
+	defer remote.Close()
+
+	localTree, err := hostData.listLocalTree()
+	if err != nil {
+		return report, err
+	}
+	remoteTree, err := hostData.listRemoteTree(remote.FS)
+	if err != nil {
+		return report, err
+	}
+
+	var compared []string
+	for relPath, localEntry := range localTree {
+		// Files above max_file_size are never synced, so they are no drift
+		if !localEntry.IsDir && hostData.checkFileSize(localEntry.Size) != nil {
+			continue
+		}
+
+		remoteEntry, exists := remoteTree[relPath]
+		switch {
+		case !exists:
+			report.Missing = append(report.Missing, relPath)
+		case localEntry.IsDir != remoteEntry.IsDir:
+			report.Differs = append(report.Differs, relPath)
+		case !localEntry.IsDir:
+			compared = append(compared, relPath)
+		}
+
+		if exists && localEntry.IsDir == remoteEntry.IsDir {
+			if mode, checked := hostData.expectedRemoteMode(localEntry); checked && mode != remoteEntry.Mode.Perm() {
+				report.Metadata = append(report.Metadata, metadataMismatch{relPath, "mode", fmt.Sprintf("%04o", mode), fmt.Sprintf("%04o", remoteEntry.Mode.Perm())})
+			}
+			if hostData.PreserveTimes && !localEntry.IsDir && localEntry.ModTime.Unix() != remoteEntry.ModTime.Unix() {
+				report.Metadata = append(report.Metadata, metadataMismatch{relPath, "mtime", localEntry.ModTime.UTC().String(), remoteEntry.ModTime.UTC().String()})
+			}
+		}
+	}
+	for relPath := range remoteTree {
+		if _, exists := localTree[relPath]; !exists {
+			report.Extra = append(report.Extra, relPath)
+		}
+	}
+
+	var hashed []string
+	for _, relPath := range compared {
+		// Files with a different size can't have the same content
+		if localTree[relPath].Size != remoteTree[relPath].Size {
+			report.Differs = append(report.Differs, relPath)
+		} else {
+			hashed = append(hashed, relPath)
+		}
+	}
+
+	remoteSums, err := remote.checksums(hostData.RemoteDir, hashed)
+	if err != nil {
+		return report, err
+	}
+	for _, relPath := range hashed {
+		localSum, err := localChecksum(hostData.localPath(relPath))
+		if err != nil {
+			return report, err
+		}
+		if localSum != remoteSums[relPath] {
+			report.Differs = append(report.Differs, relPath)
+		}
+	}
+
+	report.Missing = collapseChildren(report.Missing)
+	report.Extra = collapseChildren(report.Extra)
+	sort.Strings(report.Differs)
+	sort.Slice(report.Metadata, func(i, j int) bool { return report.Metadata[i].Path < report.Metadata[j].Path })
+	report.InSync = len(report.Missing) == 0 && len(report.Extra) == 0 && len(report.Differs) == 0 && len(report.Metadata) == 0
+
+	return report, nil
+}
+
+// collapseChildren - Sort the paths and drop the ones with any parent directory already listed.
+// Parents sort before their children, but siblings like src-old can sort in between.
+func collapseChildren(relPaths []string) []string {
+	result := []string{}
+	listed := make(map[string]bool)
+
+	sort.Strings(relPaths)
+	for _, relPath := range relPaths {
+		if !parentListed(relPath, listed) {
+			listed[relPath] = true
+			result = append(result, relPath)
+		}
+	}
+
+	return result
+}
+
+// parentListed - Check if any parent directory of a relative path is in the set
+func parentListed(relPath string, listed map[string]bool) bool {
+	for parent := path.Dir(relPath); parent != "." && parent != "/"; parent = path.Dir(parent) {
+		if listed[parent] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// localChecksum - SHA-256 of a local file
+func localChecksum(localPath string) (string, error) {
+	localFile, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer localFile.Close()
+
+	hasher := sha256.New()
+	if _, err = io.Copy(hasher, localFile); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// parseChecksums - Read the output of sha256sum for paths given as ./relPath
+func parseChecksums(output string) map[string]string {
+	result := make(map[string]string)
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// Names with a backslash or newline are escaped and marked with a leading backslash
+		escaped := strings.HasPrefix(line, "\\")
+		sum, name, found := strings.Cut(strings.TrimPrefix(line, "\\"), "  ")
+		if !found {
+			continue
+		}
+		if escaped {
+			name = strings.NewReplacer("\\\\", "\\", "\\n", "\n").Replace(name)
+		}
+		result[strings.TrimPrefix(name, "./")] = sum
+	}
+
+	return result
+}
+
+// checksums - SHA-256 of many files relative to a remote root, computed with a single sha256sum run when possible
+func (remote *remoteHost) checksums(root string, relPaths []string) (map[string]string, error) {
+	result := make(map[string]string)
+	if len(relPaths) == 0 {
+		return result, nil
+	}
+
+	session, err := remote.SSH.NewSession()
+	if err == nil {
+		defer session.Close()
+
+		var input strings.Builder
+		for _, relPath := range relPaths {
+			input.WriteString("./" + relPath + "\x00")
+		}
+		session.Stdin = strings.NewReader(input.String())
+
+		output, err := session.Output("cd " + shellQuote(root) + " && xargs -0 sha256sum --")
+		if err == nil {
+			result = parseChecksums(string(output))
+			if len(result) == len(relPaths) {
+				return result, nil
+			}
+		}
+	}
+
+	// sha256sum isn't available or skipped files, so the content is streamed over SFTP instead
+	for _, relPath := range relPaths {
+		if _, ok := result[relPath]; ok {
+			continue
+		}
+		sum, err := remote.streamChecksum(path.Join(root, relPath))
+		if err != nil {
+			return nil, err
+		}
+		result[relPath] = sum
+	}
+
+	return result, nil
+}
@@ -0,0 +1,56 @@
+package helpers
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseChecksums(t *testing.T) {
+	sumA, sumB := strings.Repeat("a", 64), strings.Repeat("b", 64)
+	output := sumA + "  ./src/main.go\n" +
+		sumB + "  ./with  two spaces\n" +
+		"\\" + sumA + "  ./new\\nline\n" +
+		"\\" + sumB + "  ./back\\\\slash\n" +
+		"\\" + sumA + "  ./both\\\\n\\n\n" +
+		"sha256sum: ./gone: No such file or directory\n"
+
+	want := map[string]string{
+		"src/main.go":      sumA,
+		"with  two spaces": sumB,
+		"new\nline":        sumA,
+		"back\\slash":      sumB,
+		"both\\n\n":        sumA,
+	}
+
+	got := parseChecksums(output)
+	if len(got) != len(want) {
+		t.Fatalf("got %d checksums %v, want %d", len(got), got, len(want))
+	}
+	for relPath, sum := range want {
+		if got[relPath] != sum {
+			t.Errorf("checksum of %q is %q, want %q", relPath, got[relPath], sum)
+		}
+	}
+}
+
+func TestCollapseChildren(t *testing.T) {
+	tests := []struct {
+		relPaths []string
+		want     []string
+	}{
+		{[]string{}, []string{}},
+		{[]string{"b", "a"}, []string{"a", "b"}},
+		{[]string{"src/a.go", "src", "src/pkg/b.go"}, []string{"src"}},
+		{[]string{"src", "src-old", "src/a"}, []string{"src", "src-old"}},
+		{[]string{"src/a", "src.bak/x", "src", "src.bak"}, []string{"src", "src.bak"}},
+		{[]string{"a/b/c", "a/b-c", "a/b", "a/b/c/d"}, []string{"a/b", "a/b-c"}},
+		{[]string{"srcfile", "src/a"}, []string{"src/a", "srcfile"}},
+	}
+
+	for _, test := range tests {
+		if got := collapseChildren(append([]string{}, test.relPaths...)); !slices.Equal(got, test.want) {
+			t.Errorf("collapseChildren(%q) = %q, want %q", test.relPaths, got, test.want)
+		}
+	}
+}
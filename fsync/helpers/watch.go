@@ -0,0 +1,381 @@
+package helpers
+
+import (
+	"fmt"
+	"github.com/radovskyb/watcher"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const watchInterval = time.Second
+const batchDelay = 300 * time.Millisecond
+
+// eventBatch - Events delivered to a host queue, done is signalled once they are applied.
+// A batch with a task runs it instead, so work on the connection never races the events.
+type eventBatch struct {
+	Events []watcher.Event
+	done   *sync.WaitGroup
+	failed *failureCount
+	task   func()
+}
+
+// failureCount - Number of hosts which failed to apply a batch
+type failureCount struct {
+	mutex sync.Mutex
+	count int
+}
+
+// watchGroup - All hosts sharing a LocalDir, watched by a single watcher.
+// Hosts with a rollout order form sequential stages, the rest get every event straight away.
+type watchGroup struct {
+	LocalDir  string
+	Immediate []*syncSession
+	Stages    [][]*syncSession
+	pending   [][]watcher.Event
+}
+
+// buildWatchGroups - Group the sessions by LocalDir and order them into rollout stages
+func buildWatchGroups(sessions []*syncSession) []*watchGroup {
+	groupsMap := make(map[string]*watchGroup)
+	orders := make(map[string]map[int][]*syncSession)
+
+	for _, session := range sessions {
+		group, ok := groupsMap[session.Host.LocalDir]
+		if !ok {
+			group = &watchGroup{LocalDir: session.Host.LocalDir}
+			groupsMap[session.Host.LocalDir] = group
+			orders[session.Host.LocalDir] = make(map[int][]*syncSession)
+		}
+
+		if session.Host.Rollout > 0 {
+			orders[session.Host.LocalDir][session.Host.Rollout] = append(orders[session.Host.LocalDir][session.Host.Rollout], session)
+		} else {
+			group.Immediate = append(group.Immediate, session)
+		}
+	}
+
+	var groups []*watchGroup
+	for localDir, group := range groupsMap {
+		var stageOrders []int
+		for order := range orders[localDir] {
+			stageOrders = append(stageOrders, order)
+		}
+		sort.Ints(stageOrders)
+
+		for _, order := range stageOrders {
+			group.Stages = append(group.Stages, orders[localDir][order])
+		}
+		group.pending = make([][]watcher.Event, len(group.Stages))
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].LocalDir < groups[j].LocalDir })
+
+	return groups
+}
+
+// sessions - Every host of the group
+func (group *watchGroup) sessions() []*syncSession {
+	result := append([]*syncSession{}, group.Immediate...)
+	for _, stage := range group.Stages {
+		result = append(result, stage...)
+	}
+
+	return result
+}
+
+// watch - Sync the hosts of the group initially, then fan the events of one watcher out to all of them.
+// ready is marked done after the initial sync, watch only returns once the watcher stopped.
+func (group *watchGroup) watch(ready *sync.WaitGroup) {
+	var petNames []string
+	for _, session := range group.sessions() {
+		petNames = append(petNames, session.PetName)
+	}
+	fmt.Printf("Monitoring %s for %s\n", group.LocalDir, strings.Join(petNames, ", "))
+
+	watcherObject := watcher.New()
+	err := watcherObject.AddRecursive(group.LocalDir)
+	if err != nil {
+		stopDashboard()
+		fmt.Println("Encountered error while trying to add file:", err)
+		os.Exit(1)
+	}
+
+	for _, session := range group.sessions() {
+		session.queue = make(chan eventBatch, 64)
+		go session.processQueue()
+	}
+
+	group.initialSync()
+	ready.Done()
+
+	batches := make(chan []watcher.Event)
+	go collectBatches(watcherObject, batches)
+	go func() {
+		for batch := range batches {
+			group.dispatch(batch)
+		}
+	}()
+
+	err = watcherObject.Start(watchInterval)
+	if err != nil {
+		fmt.Println("Encountered error while starting watcher:", err)
+	}
+}
+
+// initialSync - Resume interrupted uploads and reconcile every host, following the rollout order
+func (group *watchGroup) initialSync() {
+	var waitGroup sync.WaitGroup
+
+	for _, session := range group.Immediate {
+		waitGroup.Add(1)
+		go func(session *syncSession) {
+			defer waitGroup.Done()
+			session.runInQueue(func() { session.initialSync() })
+		}(session)
+	}
+
+	for index, stage := range group.Stages {
+		failed := false
+		for _, session := range stage {
+			session.runInQueue(func() {
+				if session.initialSync() != nil || !session.postSyncCheck() {
+					failed = true
+				}
+			})
+		}
+		if failed && index < len(group.Stages)-1 {
+			fmt.Printf("Rollout of %s halted after stage %d, the later hosts are synced once a check passes\n", group.LocalDir, index+1)
+			break
+		}
+	}
+
+	waitGroup.Wait()
+}
+
+// collectBatches - Group watcher events which arrive close together
+func collectBatches(watcherObject *watcher.Watcher, batches chan<- []watcher.Event) {
+	var batch []watcher.Event
+	timer := time.NewTimer(batchDelay)
+	timer.Stop()
+
+	for {
+		select {
+		case event := <-watcherObject.Event:
+			batch = append(batch, event)
+			timer.Reset(batchDelay)
+		case <-timer.C:
+			if batch = coalesceEvents(batch); len(batch) > 0 {
+				batches <- batch
+			}
+			batch = nil
+		case err := <-watcherObject.Error:
+			fmt.Println("Encountered error while goroutine is running:", err)
+		case <-watcherObject.Closed:
+			close(batches)
+			return
+		}
+	}
+}
+
+// dispatch - Deliver a batch to the hosts. Each rollout stage only gets it once the previous stage applied it and passed its check.
+func (group *watchGroup) dispatch(batch []watcher.Event) {
+	for _, session := range group.Immediate {
+		session.enqueue(eventBatch{Events: batch})
+	}
+	if len(group.Stages) == 0 {
+		return
+	}
+
+	group.pending[0] = append(group.pending[0], batch...)
+	for index, stage := range group.Stages {
+		events := group.pending[index]
+		if len(events) == 0 {
+			return
+		}
+		group.pending[index] = nil
+
+		var done sync.WaitGroup
+		failures := &failureCount{}
+		for _, session := range stage {
+			// Stages held back at startup catch up before applying the events
+			session.runInQueue(func() {
+				if !session.initialDone && session.initialSync() != nil {
+					failures.count++
+				}
+			})
+		}
+		for _, session := range stage {
+			done.Add(1)
+			session.enqueue(eventBatch{Events: events, done: &done, failed: failures})
+		}
+		if index == len(group.Stages)-1 {
+			return
+		}
+
+		done.Wait()
+		group.pending[index+1] = append(group.pending[index+1], events...)
+
+		passed := failures.count == 0
+		for _, session := range stage {
+			session.runInQueue(func() {
+				if !session.postSyncCheck() {
+					passed = false
+				}
+			})
+		}
+		if !passed {
+			fmt.Printf("Rollout of %s halted after stage %d, %d events are held back\n", group.LocalDir, index+1, len(group.pending[index+1]))
+			return
+		}
+	}
+}
+
+// initialSync - Resume interrupted uploads and upload everything which is outdated on the remote.
+// A host whose connection was lost is dialed again first, the sync is retried later when that fails.
+func (session *syncSession) initialSync() error {
+	if session.Remote == nil {
+		if err := session.reconnect(); err != nil {
+			err = fmt.Errorf("not connected: %w", err)
+			fmt.Printf("[%s] Encountered error during initial sync: %s\n", session.PetName, err)
+			return err
+		}
+	}
+
+	if session.Host.GitMode {
+		head, err := session.Host.gitHead()
+		if err != nil {
+			fmt.Printf("[%s] Unable to read the git HEAD: %s\n", session.PetName, err)
+		}
+		session.gitHead = head
+	}
+
+	session.resumeUploads()
+	err := session.reconcile()
+	if err != nil {
+		fmt.Printf("[%s] Encountered error during initial sync: %s\n", session.PetName, err)
+	} else {
+		lastSuccessfulSync.WithLabelValues(session.PetName).SetToCurrentTime()
+	}
+	session.initialDone = true
+
+	return err
+}
+
+// runInQueue - Run work which uses the connection on the queue of this host and wait for it to finish
+func (session *syncSession) runInQueue(task func()) {
+	var done sync.WaitGroup
+	done.Add(1)
+	session.queue <- eventBatch{done: &done, task: task}
+	done.Wait()
+}
+
+// enqueue - Hand a batch to the queue of this host
+func (session *syncSession) enqueue(batch eventBatch) {
+	queueDepth.WithLabelValues(session.PetName).Add(float64(len(batch.Events)))
+	board.update(session.PetName, func(status *hostStatus) { status.Queue += len(batch.Events) })
+	session.queue <- batch
+}
+
+// processQueue - Apply the batches delivered to this host in order
+func (session *syncSession) processQueue() {
+	for batch := range session.queue {
+		if batch.task != nil {
+			batch.task()
+			if batch.done != nil {
+				batch.done.Done()
+			}
+			continue
+		}
+		failed := false
+
+		events := batch.Events
+		if switched, err := session.retarget(); switched {
+			// The sync of the new remote_dir already covers the events
+			failed = err != nil
+			events = nil
+		} else if session.Host.GitMode {
+			events = session.gitEvents(events)
+		}
+		if len(events) != len(batch.Events) {
+			// The queue was filled with the raw events
+			queueDepth.WithLabelValues(session.PetName).Add(float64(len(events) - len(batch.Events)))
+			board.update(session.PetName, func(status *hostStatus) { status.Queue += len(events) - len(batch.Events) })
+		}
+
+		sent := session.batchEventUploads(events)
+		for _, event := range events {
+			eventsSeen.WithLabelValues(session.PetName).Inc()
+			// Uploads sent in a tar batch are already in place
+			var err error
+			if !sent[event.Path] {
+				err = session.handleEvent(event)
+			}
+			if err != nil {
+				session.Config.Failed.add(session.PetName, event, err)
+				failed = true
+			} else {
+				session.Config.Failed.resolve(session.PetName, event.Path)
+			}
+			queueDepth.WithLabelValues(session.PetName).Dec()
+			board.update(session.PetName, func(status *hostStatus) { status.Queue-- })
+		}
+		if !failed {
+			lastSuccessfulSync.WithLabelValues(session.PetName).SetToCurrentTime()
+		}
+
+		if batch.failed != nil && failed {
+			batch.failed.mutex.Lock()
+			batch.failed.count++
+			batch.failed.mutex.Unlock()
+		}
+		if batch.done != nil {
+			batch.done.Done()
+		}
+	}
+}
+
+// retarget - Follow a templated remote_dir to its new location, for example after a branch checkout, and sync it from scratch
+func (session *syncSession) retarget() (bool, error) {
+	if session.Host.remoteDirTemplate == "" {
+		return false, nil
+	}
+
+	remoteDir, err := session.Host.renderRemoteDir()
+	if err == nil && session.Remote != nil {
+		remoteDir, err = expandRemoteHome(session.Remote.FS, remoteDir)
+	}
+	if err != nil {
+		fmt.Printf("[%s] Unable to resolve remote_dir: %s\n", session.PetName, err)
+		return false, nil
+	}
+	if remoteDir == session.Host.RemoteDir {
+		return false, nil
+	}
+
+	fmt.Printf("[%s] remote_dir changed from %s to %s\n", session.PetName, session.Host.RemoteDir, remoteDir)
+	session.Host.RemoteDir = remoteDir
+	return true, session.initialSync()
+}
+
+// postSyncCheck - Run the configured check command in RemoteDir, hosts without one always pass
+func (session *syncSession) postSyncCheck() bool {
+	if session.Host.PostSyncCheck == "" {
+		return true
+	}
+	if session.Remote == nil {
+		fmt.Printf("[%s] Post-sync check failed: not connected\n", session.PetName)
+		return false
+	}
+
+	output, err := session.Remote.runCommand("cd " + shellQuote(session.Host.RemoteDir) + " && " + session.Host.PostSyncCheck)
+	if err != nil {
+		fmt.Printf("[%s] Post-sync check failed: %s\n%s", session.PetName, err, output)
+		return false
+	}
+
+	fmt.Printf("[%s] Post-sync check passed\n", session.PetName)
+	return true
+}